@@ -21,7 +21,7 @@
               pname = "parallel-socks";
               version = "unstable-${self.shortRev or "dirty"}";
               src = ./.;
-              vendorHash = "sha256-Z8V1a3uJdG/lj6AP4Xly01MQSq/yBnB2/TuERrrj0o0=";
+              vendorHash = "sha256-hWpo1ZnYPBNfvPcZMybfY0++H5AMgkYU0nXhn6HhNok=";
               buildPhase = "go build -ldflags='-s -w' -o parallel-socks ./src";
               installPhase = "mkdir -p $out/bin && cp parallel-socks $out/bin/";
             };
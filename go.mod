@@ -2,4 +2,8 @@ module github.com/bdim404/parallel-socks
 
 go 1.24.6
 
-require github.com/spf13/pflag v1.0.10 // indirect
+require (
+	github.com/spf13/pflag v1.0.10
+	golang.org/x/crypto v0.45.0
+	golang.org/x/net v0.47.0
+)
@@ -0,0 +1,196 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/listener"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/pool"
+)
+
+type Source interface {
+	Listeners() []*listener.Listener
+}
+
+type ListenerStatus struct {
+	Listen            string                `json:"listen"`
+	Protocol          string                `json:"protocol"`
+	ActiveConnections int                   `json:"active_connections"`
+	Upstreams         []pool.UpstreamStatus `json:"upstreams"`
+}
+
+type Server struct {
+	token  atomic.Pointer[string]
+	source Source
+}
+
+func New(token string, source Source) *Server {
+	s := &Server{source: source}
+	s.SetToken(token)
+	return s
+}
+
+func (s *Server) SetToken(token string) {
+	s.token.Store(&token)
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/listeners", s.listListeners)
+	mux.HandleFunc("GET /api/listeners/{listener}/upstreams", s.listUpstreams)
+	mux.HandleFunc("POST /api/listeners/{listener}/upstreams/{upstream}/disable", s.disableUpstream)
+	mux.HandleFunc("POST /api/listeners/{listener}/upstreams/{upstream}/enable", s.enableUpstream)
+	mux.HandleFunc("GET /api/connections", s.listConnections)
+	mux.HandleFunc("DELETE /api/connections/{id}", s.closeConnection)
+
+	return s.authorize(mux)
+}
+
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("serving admin api on %s/api", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(*s.token.Load())) != 1 {
+			logger.Warn("admin api request from %s rejected: invalid token", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="parallel-socks"`)
+			writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listListeners(w http.ResponseWriter, r *http.Request) {
+	listeners := s.source.Listeners()
+	states := make([]ListenerStatus, len(listeners))
+	for i, l := range listeners {
+		states[i] = ListenerStatus{
+			Listen:            l.Addr(),
+			Protocol:          l.Protocol(),
+			ActiveConnections: len(l.Connections()),
+			Upstreams:         l.Pool().Status(),
+		}
+	}
+	writeJSON(w, http.StatusOK, states)
+}
+
+func (s *Server) listUpstreams(w http.ResponseWriter, r *http.Request) {
+	l := s.listener(w, r)
+	if l == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, l.Pool().Status())
+}
+
+func (s *Server) disableUpstream(w http.ResponseWriter, r *http.Request) {
+	l := s.listener(w, r)
+	if l == nil {
+		return
+	}
+
+	var d time.Duration
+	if v := r.URL.Query().Get("duration"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid duration: "+v)
+			return
+		}
+		d = parsed
+	}
+
+	if err := l.Pool().Disable(r.PathValue("upstream"), d); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, l.Pool().Status())
+}
+
+func (s *Server) enableUpstream(w http.ResponseWriter, r *http.Request) {
+	l := s.listener(w, r)
+	if l == nil {
+		return
+	}
+
+	if err := l.Pool().Enable(r.PathValue("upstream")); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, l.Pool().Status())
+}
+
+func (s *Server) listConnections(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("listener")
+
+	conns := []listener.ConnectionInfo{}
+	for _, l := range s.source.Listeners() {
+		if filter != "" && l.Addr() != filter {
+			continue
+		}
+		conns = append(conns, l.Connections()...)
+	}
+	writeJSON(w, http.StatusOK, conns)
+}
+
+func (s *Server) closeConnection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid connection id: "+r.PathValue("id"))
+		return
+	}
+
+	for _, l := range s.source.Listeners() {
+		if l.CloseConnection(id) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "unknown connection: "+r.PathValue("id"))
+}
+
+func (s *Server) listener(w http.ResponseWriter, r *http.Request) *listener.Listener {
+	addr := r.PathValue("listener")
+	for _, l := range s.source.Listeners() {
+		if l.Addr() == addr {
+			return l
+		}
+	}
+	writeError(w, http.StatusNotFound, "unknown listener: "+addr)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/bdim404/parallel-socks/src/config"
+)
+
+type Store struct {
+	plain  map[string]string
+	hashed map[string][]byte
+}
+
+func New(cfg *config.AuthConfig) (*Store, error) {
+	s := &Store{
+		plain:  make(map[string]string),
+		hashed: make(map[string][]byte),
+	}
+
+	for _, user := range cfg.Users {
+		s.plain[user.Username] = user.Password
+	}
+
+	if cfg.HtpasswdFile != "" {
+		if err := s.loadHtpasswd(cfg.HtpasswdFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Store) loadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open htpasswd file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			return fmt.Errorf("htpasswd line %d: expected user:hash", lineNum)
+		}
+
+		if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
+			return fmt.Errorf("htpasswd line %d: unsupported hash for user %s (only bcrypt is supported)", lineNum, username)
+		}
+
+		s.hashed[username] = []byte(hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read htpasswd file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) Authenticate(username, password string) bool {
+	if hash, ok := s.hashed[username]; ok {
+		return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	}
+
+	if expected, ok := s.plain[username]; ok {
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+	}
+
+	return false
+}
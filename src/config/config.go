@@ -3,37 +3,196 @@ package config
 import (
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	LogLevel  string           `json:"log_level,omitempty"`
+	LogFormat string           `json:"log_format,omitempty"`
+	AccessLog *AccessLogConfig `json:"access_log,omitempty"`
+	Metrics   *MetricsConfig   `json:"metrics,omitempty"`
+	Admin     *AdminConfig     `json:"admin,omitempty"`
 	Listeners []ListenerConfig `json:"listeners"`
 }
 
+type AccessLogConfig struct {
+	Path   string `json:"path,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+type MetricsConfig struct {
+	Listen string `json:"listen"`
+}
+
+type AdminConfig struct {
+	Listen string `json:"listen"`
+	Token  string `json:"token"`
+}
+
+const (
+	UpstreamTypeSOCKS5 = "socks5"
+	UpstreamTypeHTTP   = "http"
+	UpstreamTypeDirect = "direct"
+)
+
 type UpstreamConfig struct {
-	Name    string `json:"name,omitempty"`
-	Address string `json:"address"`
+	Name         string   `json:"name,omitempty"`
+	Type         string   `json:"type,omitempty"`
+	Address      string   `json:"address,omitempty"`
+	Username     string   `json:"username,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	LocalAddress string   `json:"local_address,omitempty"`
+	Interface    string   `json:"interface,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+}
+
+type UserConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type AuthConfig struct {
+	Users        []UserConfig `json:"users,omitempty"`
+	HtpasswdFile string       `json:"htpasswd_file,omitempty"`
+}
+
+type AccessConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+type DestinationPolicyConfig struct {
+	DenyLoopback  bool     `json:"deny_loopback,omitempty"`
+	DenyLinkLocal bool     `json:"deny_link_local,omitempty"`
+	DenyPrivate   bool     `json:"deny_private,omitempty"`
+	DenyCIDRs     []string `json:"deny_cidrs,omitempty"`
+	DenyDomains   []string `json:"deny_domains,omitempty"`
+	DenyPorts     []string `json:"deny_ports,omitempty"`
+}
+
+type Socks4Config struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+}
+
+type UDPConfig struct {
+	PerDatagramPorts []uint16 `json:"per_datagram_ports,omitempty"`
+}
+
+type HealthCheckConfig struct {
+	Interval         Duration `json:"interval,omitempty"`
+	Timeout          Duration `json:"timeout,omitempty"`
+	ProbeTarget      string   `json:"probe_target,omitempty"`
+	FailureThreshold int      `json:"failure_threshold,omitempty"`
+	MaxBackoff       Duration `json:"max_backoff,omitempty"`
+}
+
+const (
+	AffinityKeyHost   = "host"
+	AffinityKeyDomain = "domain"
+)
+
+type AffinityConfig struct {
+	TTL        Duration `json:"ttl,omitempty"`
+	Key        string   `json:"key,omitempty"`
+	MaxEntries int      `json:"max_entries,omitempty"`
+}
+
+type WarmPoolConfig struct {
+	Size    int      `json:"size,omitempty"`
+	MaxIdle Duration `json:"max_idle,omitempty"`
 }
 
+const (
+	RaceStrategyAll       = "all"
+	RaceStrategyStaggered = "staggered"
+	RaceStrategyTopK      = "top_k"
+
+	RaceModeConnect   = "connect"
+	RaceModeHandshake = "handshake"
+)
+
+type RaceConfig struct {
+	Strategy     string   `json:"strategy,omitempty"`
+	StaggerDelay Duration `json:"stagger_delay,omitempty"`
+	TopK         int      `json:"top_k,omitempty"`
+	Explore      *int     `json:"explore,omitempty"`
+	Mode         string   `json:"mode,omitempty"`
+}
+
+const (
+	ProtocolSOCKS5 = "socks5"
+	ProtocolSOCKS4 = "socks4"
+	ProtocolHTTP   = "http"
+	ProtocolMixed  = "mixed"
+)
+
 type ListenerConfig struct {
-	Listen string           `json:"listen"`
-	Socks  []UpstreamConfig `json:"socks"`
+	Listen      string                   `json:"listen"`
+	Protocol    string                   `json:"protocol,omitempty"`
+	Socks       []UpstreamConfig         `json:"socks"`
+	DeferReply  *bool                    `json:"defer_reply,omitempty"`
+	Access      *AccessConfig            `json:"access,omitempty"`
+	Auth        *AuthConfig              `json:"auth,omitempty"`
+	Socks4      *Socks4Config            `json:"socks4,omitempty"`
+	UDP         *UDPConfig               `json:"udp,omitempty"`
+	HealthCheck *HealthCheckConfig       `json:"health_check,omitempty"`
+	StatsLog    Duration                 `json:"stats_log_interval,omitempty"`
+	Race        *RaceConfig              `json:"race,omitempty"`
+	WarmPool    *WarmPoolConfig          `json:"warm_pool,omitempty"`
+	Affinity    *AffinityConfig          `json:"affinity,omitempty"`
+	Rules       []RuleConfig             `json:"rules,omitempty"`
+	Destination *DestinationPolicyConfig `json:"destination_policy,omitempty"`
 }
 
 func (c *Config) Validate() error {
-	if c.LogLevel != "" && c.LogLevel != "debug" && c.LogLevel != "info" {
-		return fmt.Errorf("invalid log level: %s (must be 'debug' or 'info')", c.LogLevel)
-	}
-	if c.LogLevel == "" {
+	switch c.LogLevel {
+	case "":
 		c.LogLevel = "info"
+	case "trace", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid log level: %s (must be 'trace', 'debug', 'info', 'warn' or 'error')", c.LogLevel)
+	}
+
+	switch c.LogFormat {
+	case "":
+		c.LogFormat = "text"
+	case "text", "json", "logfmt":
+	default:
+		return fmt.Errorf("invalid log format: %s (must be 'text', 'json' or 'logfmt')", c.LogFormat)
+	}
+
+	if c.AccessLog != nil {
+		switch c.AccessLog.Format {
+		case "", "text", "json", "logfmt":
+		default:
+			return fmt.Errorf("invalid access log format: %s (must be 'text', 'json' or 'logfmt')", c.AccessLog.Format)
+		}
+	}
+
+	if c.Metrics != nil {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			return fmt.Errorf("invalid metrics listen address: %w", err)
+		}
+	}
+
+	if c.Admin != nil {
+		if _, _, err := net.SplitHostPort(c.Admin.Listen); err != nil {
+			return fmt.Errorf("invalid admin listen address: %w", err)
+		}
+		if c.Admin.Token == "" {
+			return fmt.Errorf("admin token is empty")
+		}
 	}
 
 	if len(c.Listeners) == 0 {
 		return fmt.Errorf("no listeners configured")
 	}
 
-	for i, listener := range c.Listeners {
-		if err := listener.Validate(); err != nil {
+	for i := range c.Listeners {
+		if err := c.Listeners[i].Validate(); err != nil {
 			return fmt.Errorf("listener %d: %w", i, err)
 		}
 	}
@@ -59,19 +218,365 @@ func (lc *ListenerConfig) Validate() error {
 		return fmt.Errorf("listen port is empty")
 	}
 
+	switch lc.Protocol {
+	case "":
+		lc.Protocol = ProtocolSOCKS5
+	case ProtocolSOCKS5, ProtocolSOCKS4, ProtocolHTTP, ProtocolMixed:
+	default:
+		return fmt.Errorf("invalid protocol: %s (must be '%s', '%s', '%s' or '%s')", lc.Protocol, ProtocolSOCKS5, ProtocolSOCKS4, ProtocolHTTP, ProtocolMixed)
+	}
+
 	if len(lc.Socks) == 0 {
 		return fmt.Errorf("no socks upstreams configured")
 	}
 
-	for i, sock := range lc.Socks {
-		if sock.Address == "" {
-			return fmt.Errorf("socks upstream %d: address is empty", i)
+	groups := make(map[string]bool)
+	for i := range lc.Socks {
+		for _, group := range lc.Socks[i].Groups {
+			groups[group] = true
 		}
-		_, _, err := net.SplitHostPort(sock.Address)
-		if err != nil {
-			return fmt.Errorf("invalid socks upstream %d (%s): %w", i, sock.Address, err)
+		if err := lc.Socks[i].Validate(); err != nil {
+			return fmt.Errorf("socks upstream %d: %w", i, err)
 		}
 	}
 
+	if lc.Access != nil {
+		if err := lc.Access.Validate(); err != nil {
+			return fmt.Errorf("access: %w", err)
+		}
+	}
+
+	if lc.Auth != nil {
+		if err := lc.Auth.Validate(); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if lc.HealthCheck != nil {
+		if err := lc.HealthCheck.Validate(); err != nil {
+			return fmt.Errorf("health check: %w", err)
+		}
+	}
+
+	if lc.UDP != nil && lc.UDP.PerDatagramPorts == nil {
+		lc.UDP.PerDatagramPorts = []uint16{53}
+	}
+
+	if lc.Race == nil {
+		lc.Race = &RaceConfig{}
+	}
+	if err := lc.Race.Validate(); err != nil {
+		return fmt.Errorf("race: %w", err)
+	}
+
+	if lc.Destination != nil {
+		if err := lc.Destination.Validate(); err != nil {
+			return fmt.Errorf("destination policy: %w", err)
+		}
+	}
+
+	for i := range lc.Rules {
+		if err := lc.Rules[i].Validate(groups); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+
+	if lc.WarmPool != nil {
+		if err := lc.WarmPool.Validate(); err != nil {
+			return fmt.Errorf("warm pool: %w", err)
+		}
+	}
+
+	if lc.Affinity != nil {
+		if err := lc.Affinity.Validate(); err != nil {
+			return fmt.Errorf("affinity: %w", err)
+		}
+	}
+
+	if lc.StatsLog < 0 {
+		return fmt.Errorf("stats log interval must not be negative")
+	}
+	if lc.StatsLog == 0 {
+		lc.StatsLog = Duration(5 * time.Minute)
+	}
+
+	if lc.DeferReply == nil {
+		deferReply := true
+		lc.DeferReply = &deferReply
+	}
+
+	return nil
+}
+
+func (uc *UpstreamConfig) Validate() error {
+	switch uc.Type {
+	case "":
+		uc.Type = UpstreamTypeSOCKS5
+	case UpstreamTypeSOCKS5, UpstreamTypeHTTP, UpstreamTypeDirect:
+	default:
+		return fmt.Errorf("invalid type: %s (must be '%s', '%s' or '%s')", uc.Type, UpstreamTypeSOCKS5, UpstreamTypeHTTP, UpstreamTypeDirect)
+	}
+
+	if uc.Type == UpstreamTypeDirect {
+		if uc.Address != "" || uc.Username != "" || uc.Password != "" {
+			return fmt.Errorf("direct upstream takes no address or credentials")
+		}
+		if uc.LocalAddress != "" && net.ParseIP(uc.LocalAddress) == nil {
+			return fmt.Errorf("invalid local address: %s", uc.LocalAddress)
+		}
+		return nil
+	}
+
+	if uc.LocalAddress != "" || uc.Interface != "" {
+		return fmt.Errorf("local address and interface are only valid for direct upstreams")
+	}
+	if uc.Address == "" {
+		return fmt.Errorf("address is empty")
+	}
+	if _, _, err := net.SplitHostPort(uc.Address); err != nil {
+		return fmt.Errorf("invalid address %s: %w", uc.Address, err)
+	}
+	if uc.Password != "" && uc.Username == "" {
+		return fmt.Errorf("%s: password set without username", uc.Address)
+	}
+	if uc.Type == UpstreamTypeSOCKS5 && (len(uc.Username) > 255 || len(uc.Password) > 255) {
+		return fmt.Errorf("%s: username and password must be at most 255 bytes", uc.Address)
+	}
+
+	return nil
+}
+
+func (ac *AccessConfig) Validate() error {
+	for _, cidr := range slices.Concat(ac.Allow, ac.Deny) {
+		if _, err := ParseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dc *DestinationPolicyConfig) Validate() error {
+	for _, cidr := range dc.DenyCIDRs {
+		if _, err := ParseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+	for _, ports := range dc.DenyPorts {
+		if _, _, err := ParsePortRange(ports); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %s", s)
+	}
+	return ipNet, nil
+}
+
+func ParseHostIP(host string) net.IP {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	return parseInetAton(host)
+}
+
+func parseInetAton(s string) net.IP {
+	parts := strings.Split(s, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+
+	var addr uint64
+	for i, part := range parts {
+		v, ok := parseInetAtonPart(part)
+		if !ok {
+			return nil
+		}
+
+		if i < len(parts)-1 {
+			if v > 0xff {
+				return nil
+			}
+			addr |= v << (8 * (3 - i))
+			continue
+		}
+
+		if v > 0xffffffff>>(8*i) {
+			return nil
+		}
+		addr |= v
+	}
+
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
+
+func parseInetAtonPart(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(part, "0x"):
+		part, base = part[2:], 16
+	case len(part) > 1 && part[0] == '0':
+		part, base = part[1:], 8
+	}
+	if part == "" {
+		return 0, base == 16
+	}
+
+	for _, c := range part {
+		if !strings.ContainsRune("0123456789abcdef"[:base], c) {
+			return 0, false
+		}
+	}
+
+	v, err := strconv.ParseUint(part, base, 32)
+	return v, err == nil
+}
+
+func (ac *AuthConfig) Validate() error {
+	if len(ac.Users) == 0 && ac.HtpasswdFile == "" {
+		return fmt.Errorf("no users or htpasswd file configured")
+	}
+
+	for i, user := range ac.Users {
+		if user.Username == "" {
+			return fmt.Errorf("user %d: username is empty", i)
+		}
+		if len(user.Username) > 255 || len(user.Password) > 255 {
+			return fmt.Errorf("user %d (%s): username and password must be at most 255 bytes", i, user.Username)
+		}
+	}
+
+	return nil
+}
+
+func (hc *HealthCheckConfig) Validate() error {
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.MaxBackoff < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	if hc.FailureThreshold < 0 {
+		return fmt.Errorf("failure threshold must not be negative")
+	}
+
+	if hc.Interval == 0 {
+		hc.Interval = Duration(30 * time.Second)
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = Duration(5 * time.Second)
+	}
+	if hc.FailureThreshold == 0 {
+		hc.FailureThreshold = 2
+	}
+	if hc.MaxBackoff == 0 {
+		hc.MaxBackoff = Duration(5 * time.Minute)
+	}
+	if hc.MaxBackoff < hc.Interval {
+		hc.MaxBackoff = hc.Interval
+	}
+
+	if hc.ProbeTarget != "" {
+		if _, _, err := net.SplitHostPort(hc.ProbeTarget); err != nil {
+			return fmt.Errorf("invalid probe target %s: %w", hc.ProbeTarget, err)
+		}
+	}
+
+	return nil
+}
+
+func (wc *WarmPoolConfig) Validate() error {
+	if wc.Size < 0 {
+		return fmt.Errorf("size must not be negative")
+	}
+	if wc.MaxIdle < 0 {
+		return fmt.Errorf("max idle must not be negative")
+	}
+
+	if wc.Size == 0 {
+		wc.Size = 2
+	}
+	if wc.MaxIdle == 0 {
+		wc.MaxIdle = Duration(30 * time.Second)
+	}
+
+	return nil
+}
+
+func (ac *AffinityConfig) Validate() error {
+	if ac.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if ac.MaxEntries < 0 {
+		return fmt.Errorf("max entries must not be negative")
+	}
+
+	if ac.TTL == 0 {
+		ac.TTL = Duration(10 * time.Minute)
+	}
+	if ac.MaxEntries == 0 {
+		ac.MaxEntries = 4096
+	}
+
+	switch ac.Key {
+	case "":
+		ac.Key = AffinityKeyHost
+	case AffinityKeyHost, AffinityKeyDomain:
+	default:
+		return fmt.Errorf("invalid key: %s (must be '%s' or '%s')", ac.Key, AffinityKeyHost, AffinityKeyDomain)
+	}
+
+	return nil
+}
+
+func (rc *RaceConfig) Validate() error {
+	switch rc.Strategy {
+	case "":
+		rc.Strategy = RaceStrategyAll
+	case RaceStrategyAll, RaceStrategyStaggered, RaceStrategyTopK:
+	default:
+		return fmt.Errorf("invalid strategy: %s (must be '%s', '%s' or '%s')", rc.Strategy, RaceStrategyAll, RaceStrategyStaggered, RaceStrategyTopK)
+	}
+
+	if rc.StaggerDelay < 0 {
+		return fmt.Errorf("stagger delay must not be negative")
+	}
+	if rc.StaggerDelay == 0 {
+		rc.StaggerDelay = Duration(150 * time.Millisecond)
+	}
+
+	if rc.TopK < 0 {
+		return fmt.Errorf("top_k must not be negative")
+	}
+	if rc.TopK == 0 {
+		rc.TopK = 3
+	}
+
+	if rc.Explore == nil {
+		explore := 1
+		rc.Explore = &explore
+	}
+	if *rc.Explore < 0 {
+		return fmt.Errorf("explore must not be negative")
+	}
+
+	switch rc.Mode {
+	case "":
+		rc.Mode = RaceModeConnect
+	case RaceModeConnect, RaceModeHandshake:
+	default:
+		return fmt.Errorf("invalid mode: %s (must be '%s' or '%s')", rc.Mode, RaceModeConnect, RaceModeHandshake)
+	}
+
 	return nil
 }
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	RuleActionRace   = "race"
+	RuleActionDirect = "direct"
+	RuleActionReject = "reject"
+)
+
+type RuleConfig struct {
+	Domains        []string `json:"domains,omitempty"`
+	DomainSuffixes []string `json:"domain_suffixes,omitempty"`
+	DomainRegexes  []string `json:"domain_regexes,omitempty"`
+	CIDRs          []string `json:"cidrs,omitempty"`
+	Ports          []string `json:"ports,omitempty"`
+	Action         string   `json:"action,omitempty"`
+	Group          string   `json:"group,omitempty"`
+}
+
+func (rc *RuleConfig) Validate(groups map[string]bool) error {
+	switch rc.Action {
+	case "":
+		rc.Action = RuleActionRace
+	case RuleActionRace, RuleActionDirect, RuleActionReject:
+	default:
+		return fmt.Errorf("invalid action: %s (must be '%s', '%s' or '%s')", rc.Action, RuleActionRace, RuleActionDirect, RuleActionReject)
+	}
+
+	if rc.Group != "" {
+		if rc.Action != RuleActionRace {
+			return fmt.Errorf("group is only valid with action '%s'", RuleActionRace)
+		}
+		if !groups[rc.Group] {
+			return fmt.Errorf("unknown upstream group: %s", rc.Group)
+		}
+	}
+
+	for _, expr := range rc.DomainRegexes {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid domain regex %s: %w", expr, err)
+		}
+	}
+
+	for _, cidr := range rc.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %s: %w", cidr, err)
+		}
+	}
+
+	for _, ports := range rc.Ports {
+		if _, _, err := ParsePortRange(ports); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ParsePortRange(s string) (uint16, uint16, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+
+	first, err := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %s", s)
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if err != nil || last < first {
+		return 0, 0, fmt.Errorf("invalid port range %s", s)
+	}
+
+	return uint16(first), uint16(last), nil
+}
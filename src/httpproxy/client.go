@@ -0,0 +1,144 @@
+package httpproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+const maxResponseHeaderSize = 8 << 10
+
+func DialConnect(ctx context.Context, proxyAddr string, creds *socks5.Credentials, target *socks5.TargetAddress) (net.Conn, error) {
+	conn, err := DialNegotiated(ctx, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Connect(ctx, conn, creds, target); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func DialNegotiated(ctx context.Context, proxyAddr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: 2 * time.Second,
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, &socks5.ProxyDialError{Err: err}
+	}
+	return conn, nil
+}
+
+func Connect(ctx context.Context, conn net.Conn, creds *socks5.Credentials, target *socks5.TargetAddress) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	hostPort := target.String()
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", hostPort, hostPort)
+	if creds != nil {
+		token := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+		req += "Proxy-Authorization: Basic " + token + "\r\n"
+	}
+	req += "\r\n"
+
+	logger.Trace("http: sending connect request to %s", hostPort)
+	if _, err := io.WriteString(conn, req); err != nil {
+		return fmt.Errorf("write connect request: %w", err)
+	}
+
+	header, err := readResponseHeader(conn)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(header)), nil)
+	if err != nil {
+		return fmt.Errorf("parse connect response: %w", err)
+	}
+	resp.Body.Close()
+
+	logger.Trace("http: received connect response: %s", resp.Status)
+
+	if err := errorForStatus(resp.StatusCode, creds); err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return nil
+}
+
+func Probe(ctx context.Context, proxyAddr string, creds *socks5.Credentials, target *socks5.TargetAddress) error {
+	conn, err := DialNegotiated(ctx, proxyAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if target == nil {
+		return nil
+	}
+
+	return Connect(ctx, conn, creds, target)
+}
+
+func readResponseHeader(conn net.Conn) ([]byte, error) {
+	header := make([]byte, 0, 256)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(header, []byte("\r\n\r\n")) {
+		if len(header) >= maxResponseHeaderSize {
+			return nil, fmt.Errorf("connect response header exceeds %d bytes", maxResponseHeaderSize)
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, fmt.Errorf("read connect response: %w", err)
+		}
+		header = append(header, b[0])
+	}
+	return header, nil
+}
+
+func errorForStatus(status int, creds *socks5.Credentials) error {
+	if status >= 200 && status < 300 {
+		return nil
+	}
+
+	if status == http.StatusProxyAuthRequired {
+		if creds == nil {
+			return &socks5.AuthError{Message: "proxy requires authentication but no credentials are configured"}
+		}
+		return &socks5.AuthError{Message: fmt.Sprintf("authentication failed for user %q: status %d", creds.Username, status)}
+	}
+
+	var code byte
+	switch status {
+	case http.StatusForbidden, http.StatusMethodNotAllowed:
+		code = socks5.RepConnectionNotAllowed
+	case http.StatusNotFound, http.StatusGone, http.StatusBadGateway:
+		code = socks5.RepHostUnreachable
+	case http.StatusServiceUnavailable:
+		code = socks5.RepNetworkUnreachable
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		code = socks5.RepTTLExpired
+	default:
+		code = socks5.RepGeneralFailure
+	}
+
+	return &socks5.SOCKS5Error{
+		ReplyCode: code,
+		Message:   fmt.Sprintf("connection failed: http status %d", status),
+	}
+}
@@ -0,0 +1,60 @@
+package listener
+
+import (
+	"net"
+
+	"github.com/bdim404/parallel-socks/src/config"
+)
+
+type accessList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func newAccessList(cfg *config.AccessConfig) (*accessList, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	acl := &accessList{}
+	for _, cidr := range cfg.Allow {
+		ipNet, err := config.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		acl.allow = append(acl.allow, ipNet)
+	}
+	for _, cidr := range cfg.Deny {
+		ipNet, err := config.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		acl.deny = append(acl.deny, ipNet)
+	}
+	return acl, nil
+}
+
+func (acl *accessList) permits(addr net.Addr) bool {
+	if acl == nil {
+		return true
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	if containsIP(acl.deny, tcpAddr.IP) {
+		return false
+	}
+	return len(acl.allow) == 0 || containsIP(acl.allow, tcpAddr.IP)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package listener
+
+import (
+	"context"
+	"net"
+
+	"github.com/bdim404/parallel-socks/src/logger"
+)
+
+const (
+	reasonClosed          = "closed"
+	reasonHandshakeFailed = "handshake_failed"
+	reasonDenied          = "denied"
+	reasonRejected        = "rejected"
+	reasonUpstreamFailed  = "upstream_failed"
+	reasonClientClosed    = "client_closed"
+	reasonUpstreamClosed  = "upstream_closed"
+	reasonClientError     = "client_error"
+	reasonUpstreamError   = "upstream_error"
+	reasonShutdown        = "shutdown"
+	reasonAdminClosed     = "admin_closed"
+)
+
+func (l *Listener) logAccess(ctx context.Context, clientConn net.Conn, rec *connRecord) {
+	if !logger.AccessEnabled() {
+		return
+	}
+
+	info := rec.info(l.addr)
+	reason := rec.closeReason()
+	user := info.User
+	if user == "" {
+		user = "-"
+	}
+	target := info.Target
+	if target == "" {
+		target = "-"
+	}
+	upstream := info.Upstream
+	if upstream == "" {
+		upstream = "-"
+	}
+
+	logger.FromContext(ctx).With(
+		"user", user,
+		"target", target,
+		"upstream", upstream,
+		"race_ms", info.RaceMS,
+		"bytes_up", info.BytesUp,
+		"bytes_down", info.BytesDown,
+		"duration_ms", info.DurationMS,
+		"reason", reason,
+	).Access("access %s user=%s -> %s via %s race=%dms up=%d down=%d duration=%dms reason=%s",
+		clientConn.RemoteAddr(), user, target, upstream, info.RaceMS, info.BytesUp, info.BytesDown, info.DurationMS, reason)
+}
+
+func copyReason(fromClient bool, err, writeErr error) string {
+	if writeErr != nil {
+		return closeReason(!fromClient, writeErr)
+	}
+	return closeReason(fromClient, err)
+}
+
+func closeReason(client bool, err error) string {
+	switch {
+	case client && err == nil:
+		return reasonClientClosed
+	case client:
+		return reasonClientError
+	case err == nil:
+		return reasonUpstreamClosed
+	default:
+		return reasonUpstreamError
+	}
+}
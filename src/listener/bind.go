@@ -0,0 +1,125 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/pool"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+func (l *Listener) handleBind(ctx context.Context, clientConn net.Conn, target *socks5.TargetAddress) {
+	log := logger.FromContext(ctx)
+
+	if err := l.checkDestination(ctx, clientConn, target); err != nil {
+		socks5.SendReply(clientConn, socks5.RepConnectionNotAllowed, nil)
+		return
+	}
+
+	decision := l.matchRoute(ctx, target)
+	switch decision.Action {
+	case config.RuleActionReject:
+		socks5.SendReply(clientConn, socks5.RepConnectionNotAllowed, nil)
+		return
+	case config.RuleActionDirect:
+		log.Info("bind for %s rejected: direct bind is not supported", clientConn.RemoteAddr())
+		recordFrom(ctx).fail(reasonRejected)
+		socks5.SendReply(clientConn, socks5.RepCommandNotSupported, nil)
+		return
+	}
+
+	upstreamConn, err := l.pool.Bind(ctx, target, decision.Group)
+	if err != nil {
+		recordFrom(ctx).fail(reasonUpstreamFailed)
+		socks5.SendReply(clientConn, socks5.ReplyCodeForError(err), nil)
+		return
+	}
+	defer upstreamConn.Close()
+	recordFrom(ctx).setUpstream(upstreamConn)
+
+	bindAddr := reachableBindAddr(upstreamConn)
+	if err := socks5.SendReply(clientConn, socks5.RepSuccess, bindAddr); err != nil {
+		log.Info("send bind reply failed: %v", err)
+		return
+	}
+
+	log.Info("bind for %s listening on %v via %s", clientConn.RemoteAddr(), bindAddr, upstreamConn.Upstream.Address)
+
+	waitDone := make(chan struct{})
+	defer close(waitDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			upstreamConn.Close()
+		case <-waitDone:
+		}
+	}()
+
+	br := bufio.NewReader(clientConn)
+	clientGone := make(chan error, 1)
+	go func() {
+		clientGone <- watchClient(br, upstreamConn)
+	}()
+
+	peerAddr, err := socks5.ReadBindReply(upstreamConn)
+	clientConn.SetReadDeadline(time.Now())
+	clientErr := <-clientGone
+	clientConn.SetReadDeadline(time.Time{})
+
+	if clientErr != nil {
+		log.Info("✗ bind for %s abandoned by client: %v", target, clientErr)
+		if errors.Is(clientErr, io.EOF) {
+			clientErr = nil
+		}
+		recordFrom(ctx).fail(closeReason(true, clientErr))
+		return
+	}
+	if err != nil {
+		log.Info("✗ bind for %s failed waiting for peer: %v", target, err)
+		recordFrom(ctx).fail(reasonUpstreamError)
+		socks5.SendReply(clientConn, socks5.ReplyCodeForError(err), nil)
+		return
+	}
+
+	if err := socks5.SendReply(clientConn, socks5.RepSuccess, peerAddr); err != nil {
+		log.Info("send bind reply failed: %v", err)
+		return
+	}
+
+	log.Info("bind for %s accepted peer %v", clientConn.RemoteAddr(), peerAddr)
+
+	l.relay(ctx, &bufferedConn{Conn: clientConn, r: br}, upstreamConn)
+}
+
+func watchClient(br *bufio.Reader, upstreamConn net.Conn) error {
+	for n := 1; n <= br.Size(); n++ {
+		if _, err := br.Peek(n); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			upstreamConn.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func reachableBindAddr(upstreamConn *pool.Conn) net.Addr {
+	bindAddr, ok := upstreamConn.BindAddr.(*net.TCPAddr)
+	if !ok || !bindAddr.IP.IsUnspecified() {
+		return upstreamConn.BindAddr
+	}
+
+	remote, ok := upstreamConn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return upstreamConn.BindAddr
+	}
+	return &net.TCPAddr{IP: remote.IP, Port: bindAddr.Port}
+}
@@ -0,0 +1,188 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/pool"
+)
+
+var errClosedByAdmin = errors.New("closed by admin")
+
+type connRecord struct {
+	id     uint64
+	client string
+	start  time.Time
+	conn   net.Conn
+	cancel context.CancelCauseFunc
+	up     atomic.Uint64
+	down   atomic.Uint64
+
+	mu          sync.Mutex
+	user        string
+	target      string
+	upstream    string
+	race        time.Duration
+	reason      string
+	adminClosed bool
+}
+
+type ConnectionInfo struct {
+	ID         uint64    `json:"id"`
+	Listener   string    `json:"listener"`
+	Client     string    `json:"client"`
+	User       string    `json:"user,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	RaceMS     int64     `json:"race_ms"`
+	BytesUp    uint64    `json:"bytes_up"`
+	BytesDown  uint64    `json:"bytes_down"`
+	Started    time.Time `json:"started"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+type recordKey struct{}
+
+func (l *Listener) track(ctx context.Context, id uint64, conn net.Conn) (context.Context, *connRecord) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	rec := &connRecord{
+		id:     id,
+		client: conn.RemoteAddr().String(),
+		start:  time.Now(),
+		conn:   conn,
+		cancel: cancel,
+		reason: reasonClosed,
+	}
+
+	l.connsMu.Lock()
+	l.conns[id] = rec
+	l.connsMu.Unlock()
+
+	return context.WithValue(ctx, recordKey{}, rec), rec
+}
+
+func (l *Listener) untrack(rec *connRecord) {
+	l.connsMu.Lock()
+	delete(l.conns, rec.id)
+	l.connsMu.Unlock()
+	rec.cancel(nil)
+}
+
+func recordFrom(ctx context.Context) *connRecord {
+	if rec, ok := ctx.Value(recordKey{}).(*connRecord); ok {
+		return rec
+	}
+	return &connRecord{start: time.Now(), cancel: func(error) {}}
+}
+
+func (r *connRecord) fail(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reason = reason
+}
+
+func (r *connRecord) setUser(user string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.user = user
+}
+
+func (r *connRecord) setTarget(target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.target = target
+}
+
+func (r *connRecord) setUpstream(conn *pool.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.upstream = pool.UpstreamLabel(conn.Upstream)
+	r.race = conn.Duration
+}
+
+func (r *connRecord) setRace(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.race = d
+}
+
+func (r *connRecord) addUpstream(label string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.upstream == "" {
+		r.upstream = label
+		return
+	}
+	if !slices.Contains(strings.Split(r.upstream, ","), label) {
+		r.upstream += "," + label
+	}
+}
+
+func (r *connRecord) closeReason() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.adminClosed {
+		return reasonAdminClosed
+	}
+	return r.reason
+}
+
+func (r *connRecord) info(listener string) ConnectionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ConnectionInfo{
+		ID:         r.id,
+		Listener:   listener,
+		Client:     r.client,
+		User:       r.user,
+		Target:     r.target,
+		Upstream:   r.upstream,
+		RaceMS:     r.race.Milliseconds(),
+		BytesUp:    r.up.Load(),
+		BytesDown:  r.down.Load(),
+		Started:    r.start,
+		DurationMS: time.Since(r.start).Milliseconds(),
+	}
+}
+
+func (l *Listener) Connections() []ConnectionInfo {
+	l.connsMu.Lock()
+	records := make([]*connRecord, 0, len(l.conns))
+	for _, rec := range l.conns {
+		records = append(records, rec)
+	}
+	l.connsMu.Unlock()
+
+	conns := make([]ConnectionInfo, len(records))
+	for i, rec := range records {
+		conns[i] = rec.info(l.addr)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
+
+func (l *Listener) CloseConnection(id uint64) bool {
+	l.connsMu.Lock()
+	rec, ok := l.conns[id]
+	l.connsMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	rec.mu.Lock()
+	rec.adminClosed = true
+	rec.mu.Unlock()
+
+	logger.With("conn_id", id, "client", rec.client, "listener", l.addr).Info("closing connection from %s by admin request", rec.client)
+	rec.cancel(errClosedByAdmin)
+	rec.conn.Close()
+	return true
+}
@@ -4,59 +4,218 @@ import (
 	"context"
 	"io"
 	"net"
+	"sync/atomic"
 
+	"github.com/bdim404/parallel-socks/src/config"
 	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/metrics"
+	"github.com/bdim404/parallel-socks/src/policy"
 	"github.com/bdim404/parallel-socks/src/pool"
+	"github.com/bdim404/parallel-socks/src/route"
 	"github.com/bdim404/parallel-socks/src/socks5"
 )
 
-func handleConnection(ctx context.Context, clientConn net.Conn, p *pool.Pool) {
+func (l *Listener) handleConnection(ctx context.Context, clientConn net.Conn, id uint64) {
 	defer clientConn.Close()
 
-	if err := socks5.HandleNegotiation(clientConn); err != nil {
-		logger.Info("negotiation failed: %v", err)
+	connCtx, rec := l.track(ctx, id, clientConn)
+	defer l.untrack(rec)
+	defer l.logAccess(ctx, clientConn, rec)
+	ctx = connCtx
+
+	switch l.config().Protocol {
+	case config.ProtocolSOCKS4:
+		l.handleSOCKS4(ctx, clientConn)
+	case config.ProtocolHTTP:
+		l.handleHTTP(ctx, clientConn)
+	case config.ProtocolMixed:
+		l.handleMixed(ctx, clientConn)
+	default:
+		l.handleSOCKS5(ctx, clientConn)
+	}
+}
+
+func (l *Listener) handleSOCKS5(ctx context.Context, clientConn net.Conn) {
+	log := logger.FromContext(ctx)
+
+	username, err := socks5.HandleNegotiation(clientConn, l.state.Load().auth)
+	if err != nil {
+		log.Warn("negotiation failed: %v", err)
+		metrics.NegotiationFailures.With(l.addr).Inc()
+		recordFrom(ctx).fail(reasonHandshakeFailed)
+		return
+	}
+
+	if username != "" {
+		log.Info("authenticated %s as %s", clientConn.RemoteAddr(), username)
+		ctx = withUser(ctx, username)
+	}
+
+	cmd, target, err := socks5.ParseRequest(clientConn)
+	if err != nil {
+		log.Warn("parse request failed: %v", err)
+		metrics.RequestParseFailures.With(l.addr).Inc()
+		recordFrom(ctx).fail(reasonHandshakeFailed)
+		socks5.SendReply(clientConn, socks5.ReplyCodeForError(err), nil)
 		return
 	}
+	ctx = withTarget(ctx, target)
+
+	switch cmd {
+	case socks5.CmdBind:
+		l.handleBind(ctx, clientConn, target)
+	case socks5.CmdUDPAssociate:
+		l.handleUDPAssociate(ctx, clientConn, target)
+	default:
+		l.handleConnect(ctx, clientConn, target)
+	}
+}
+
+func withUser(ctx context.Context, user string) context.Context {
+	recordFrom(ctx).setUser(user)
+	return logger.NewContext(ctx, logger.FromContext(ctx).With("user", user))
+}
+
+func withTarget(ctx context.Context, target *socks5.TargetAddress) context.Context {
+	recordFrom(ctx).setTarget(target.String())
+	return logger.NewContext(ctx, logger.FromContext(ctx).With("target", target.String()))
+}
+
+func (l *Listener) matchRoute(ctx context.Context, target *socks5.TargetAddress) route.Decision {
+	log := logger.FromContext(ctx)
+	decision := l.state.Load().router.Match(target)
+	if decision.Rule >= 0 {
+		log.Debug("%s matched rule %d: %s %s", target, decision.Rule, decision.Action, decision.Group)
+	}
+	if decision.Action == config.RuleActionReject {
+		log.Info("✗ %s rejected by rule %d", target, decision.Rule)
+		recordFrom(ctx).fail(reasonRejected)
+	}
+	return decision
+}
+
+func (l *Listener) checkDestination(ctx context.Context, clientConn net.Conn, target *socks5.TargetAddress) error {
+	err := l.state.Load().policy.Check(target)
+	if err != nil {
+		logger.FromContext(ctx).Warn("✗ %s from %s: %v", target, clientConn.RemoteAddr(), err)
+		recordFrom(ctx).fail(reasonDenied)
+	}
+	return err
+}
 
-	target, err := socks5.ParseRequest(clientConn)
+func (l *Listener) dial(ctx context.Context, target *socks5.TargetAddress, decision route.Decision) (*pool.Conn, error) {
+	ctx = policy.NewContext(ctx, l.state.Load().policy)
+
+	var conn *pool.Conn
+	var err error
+	if decision.Action == config.RuleActionDirect {
+		conn, err = l.pool.DialDirect(ctx, target)
+	} else {
+		conn, err = l.pool.GetConn(ctx, target, decision.Group)
+	}
 	if err != nil {
-		logger.Info("parse request failed: %v", err)
-		socks5.SendReply(clientConn, socks5.RepGeneralFailure, nil)
+		recordFrom(ctx).fail(reasonUpstreamFailed)
+		return nil, err
+	}
+	recordFrom(ctx).setUpstream(conn)
+	return conn, nil
+}
+
+func (l *Listener) handleConnect(ctx context.Context, clientConn net.Conn, target *socks5.TargetAddress) {
+	log := logger.FromContext(ctx)
+
+	cfg := l.config()
+	deferReply := cfg.DeferReply == nil || *cfg.DeferReply
+
+	if err := l.checkDestination(ctx, clientConn, target); err != nil {
+		socks5.SendReply(clientConn, socks5.RepConnectionNotAllowed, nil)
 		return
 	}
 
-	if err := socks5.SendReply(clientConn, socks5.RepSuccess, clientConn.LocalAddr()); err != nil {
-		logger.Info("send reply failed: %v", err)
+	decision := l.matchRoute(ctx, target)
+	if decision.Action == config.RuleActionReject {
+		socks5.SendReply(clientConn, socks5.RepConnectionNotAllowed, nil)
 		return
 	}
 
-	upstreamConn, _, err := p.GetConn(ctx, target)
+	if !deferReply {
+		if err := socks5.SendReply(clientConn, socks5.RepSuccess, clientConn.LocalAddr()); err != nil {
+			log.Info("send reply failed: %v", err)
+			return
+		}
+	}
+
+	upstreamConn, err := l.dial(ctx, target, decision)
 	if err != nil {
+		if deferReply {
+			socks5.SendReply(clientConn, socks5.ReplyCodeForError(err), nil)
+		}
 		return
 	}
 	defer upstreamConn.Close()
 
-	done := make(chan struct{}, 2)
+	if deferReply {
+		bindAddr := upstreamConn.BindAddr
+		if bindAddr == nil {
+			bindAddr = clientConn.LocalAddr()
+		}
+		if err := socks5.SendReply(clientConn, socks5.RepSuccess, bindAddr); err != nil {
+			log.Info("send reply failed: %v", err)
+			return
+		}
+	}
+
+	l.relay(ctx, clientConn, upstreamConn)
+}
+
+type countingWriter struct {
+	w       io.Writer
+	counter *metrics.Counter
+	total   *atomic.Uint64
+	err     error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.counter.Add(uint64(n))
+	cw.total.Add(uint64(n))
+	if err != nil {
+		cw.err = err
+	}
+	return n, err
+}
+
+func (l *Listener) relay(ctx context.Context, clientConn net.Conn, upstreamConn *pool.Conn) {
+	rec := recordFrom(ctx)
+	label := pool.UpstreamLabel(upstreamConn.Upstream)
+	up := &countingWriter{w: upstreamConn, counter: metrics.BytesTransferred.With(l.addr, label, "up"), total: &rec.up}
+	down := &countingWriter{w: clientConn, counter: metrics.BytesTransferred.With(l.addr, label, "down"), total: &rec.down}
+
+	done := make(chan string, 2)
 
 	go func() {
-		io.Copy(upstreamConn, clientConn)
+		_, err := io.Copy(up, clientConn)
+		done <- copyReason(true, err, up.err)
 		upstreamConn.Close()
-		done <- struct{}{}
 	}()
 
 	go func() {
-		io.Copy(clientConn, upstreamConn)
+		_, err := io.Copy(down, upstreamConn)
+		done <- copyReason(false, err, down.err)
 		clientConn.Close()
-		done <- struct{}{}
 	}()
 
+	var reason string
 	select {
-	case <-done:
+	case reason = <-done:
 		<-done
 	case <-ctx.Done():
+		reason = reasonShutdown
 		clientConn.Close()
 		upstreamConn.Close()
 		<-done
 		<-done
 	}
+
+	rec.fail(reason)
 }
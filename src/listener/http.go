@@ -0,0 +1,191 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/metrics"
+	"github.com/bdim404/parallel-socks/src/pool"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Upgrade",
+}
+
+func (l *Listener) handleHTTP(ctx context.Context, clientConn net.Conn) {
+	log := logger.FromContext(ctx)
+
+	br := bufio.NewReader(clientConn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		log.Warn("parse http request failed: %v", err)
+		metrics.RequestParseFailures.With(l.addr).Inc()
+		recordFrom(ctx).fail(reasonHandshakeFailed)
+		writeHTTPStatus(clientConn, http.StatusBadRequest, "")
+		return
+	}
+
+	if authenticator := l.state.Load().auth; authenticator != nil {
+		username, ok := proxyAuthorization(req, authenticator)
+		if !ok {
+			log.Warn("http proxy authentication failed for %s", clientConn.RemoteAddr())
+			metrics.NegotiationFailures.With(l.addr).Inc()
+			recordFrom(ctx).fail(reasonHandshakeFailed)
+			writeHTTPStatus(clientConn, http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm=\"parallel-socks\"\r\n")
+			return
+		}
+		log.Info("authenticated %s as %s", clientConn.RemoteAddr(), username)
+		ctx = withUser(ctx, username)
+	}
+
+	target, err := httpTarget(req)
+	if err != nil {
+		log.Warn("parse http request failed: %v", err)
+		metrics.RequestParseFailures.With(l.addr).Inc()
+		recordFrom(ctx).fail(reasonHandshakeFailed)
+		writeHTTPStatus(clientConn, http.StatusBadRequest, "")
+		return
+	}
+	ctx = withTarget(ctx, target)
+
+	if err := l.checkDestination(ctx, clientConn, target); err != nil {
+		writeHTTPStatus(clientConn, http.StatusForbidden, "")
+		return
+	}
+
+	decision := l.matchRoute(ctx, target)
+	if decision.Action == config.RuleActionReject {
+		writeHTTPStatus(clientConn, http.StatusForbidden, "")
+		return
+	}
+
+	upstreamConn, err := l.dial(ctx, target, decision)
+	if err != nil {
+		writeHTTPStatus(clientConn, httpStatusForError(err), "")
+		return
+	}
+	defer upstreamConn.Close()
+
+	if req.Method == http.MethodConnect {
+		if _, err := io.WriteString(clientConn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			log.Info("send http reply failed: %v", err)
+			return
+		}
+		l.relay(ctx, &bufferedConn{Conn: clientConn, r: br}, upstreamConn)
+		return
+	}
+
+	l.forwardHTTP(ctx, clientConn, req, upstreamConn)
+}
+
+func (l *Listener) forwardHTTP(ctx context.Context, clientConn net.Conn, req *http.Request, upstreamConn *pool.Conn) {
+	log := logger.FromContext(ctx)
+
+	for _, value := range req.Header.Values("Connection") {
+		for _, h := range strings.Split(value, ",") {
+			req.Header.Del(strings.TrimSpace(h))
+		}
+	}
+	for _, h := range hopByHopHeaders {
+		req.Header.Del(h)
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "")
+	}
+	req.Close = true
+
+	rec := recordFrom(ctx)
+	label := pool.UpstreamLabel(upstreamConn.Upstream)
+	up := &countingWriter{w: upstreamConn, counter: metrics.BytesTransferred.With(l.addr, label, "up"), total: &rec.up}
+	down := &countingWriter{w: clientConn, counter: metrics.BytesTransferred.With(l.addr, label, "down"), total: &rec.down}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			clientConn.Close()
+			upstreamConn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := req.Write(up); err != nil {
+		log.Info("forward http request to %s failed: %v", req.Host, err)
+		rec.fail(reasonUpstreamError)
+		writeHTTPStatus(clientConn, http.StatusBadGateway, "")
+		return
+	}
+
+	_, err := io.Copy(down, upstreamConn)
+	if ctx.Err() != nil {
+		rec.fail(reasonShutdown)
+		return
+	}
+	rec.fail(copyReason(false, err, down.err))
+}
+
+func httpTarget(req *http.Request) (*socks5.TargetAddress, error) {
+	if req.Method == http.MethodConnect {
+		return socks5.ParseTargetAddress(req.Host)
+	}
+
+	if !req.URL.IsAbs() || req.URL.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported request URI: %s", req.RequestURI)
+	}
+
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+	}
+	return socks5.ParseTargetAddress(net.JoinHostPort(req.URL.Hostname(), port))
+}
+
+func proxyAuthorization(req *http.Request, authenticator socks5.Authenticator) (string, bool) {
+	scheme, encoded, ok := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", false
+	}
+
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok || !authenticator.Authenticate(username, password) {
+		return "", false
+	}
+	return username, true
+}
+
+func httpStatusForError(err error) int {
+	switch socks5.ReplyCodeForError(err) {
+	case socks5.RepConnectionNotAllowed:
+		return http.StatusForbidden
+	case socks5.RepTTLExpired:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeHTTPStatus(conn net.Conn, status int, headers string) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n%sContent-Length: 0\r\nConnection: close\r\n\r\n", status, http.StatusText(status), headers)
+}
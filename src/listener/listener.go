@@ -2,66 +2,246 @@ package listener
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bdim404/parallel-socks/src/auth"
 	"github.com/bdim404/parallel-socks/src/config"
 	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/metrics"
+	"github.com/bdim404/parallel-socks/src/policy"
 	"github.com/bdim404/parallel-socks/src/pool"
+	"github.com/bdim404/parallel-socks/src/route"
+	"github.com/bdim404/parallel-socks/src/socks5"
 )
 
+var connIDs atomic.Uint64
+
 type Listener struct {
-	cfg  *config.ListenerConfig
-	ln   net.Listener
-	pool *pool.Pool
-	wg   sync.WaitGroup
+	addr  string
+	ln    net.Listener
+	pool  *pool.Pool
+	state atomic.Pointer[listenerState]
+	wg    sync.WaitGroup
+
+	mu       sync.Mutex
+	ctx      context.Context
+	bgCancel context.CancelFunc
+	stop     context.CancelFunc
+
+	warmCancel context.CancelFunc
+	warmCfg    *config.WarmPoolConfig
+
+	connsMu sync.Mutex
+	conns   map[uint64]*connRecord
+}
+
+type listenerState struct {
+	cfg    *config.ListenerConfig
+	access *accessList
+	auth   socks5.Authenticator
+	policy *policy.Policy
+	router *route.Router
 }
 
 func New(cfg *config.ListenerConfig) (*Listener, error) {
+	state, err := newState(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	ln, err := net.Listen("tcp", cfg.Listen)
 	if err != nil {
 		return nil, err
 	}
 
-	p := pool.New(cfg.Socks, 5*time.Second)
+	p := pool.New(cfg.Listen, cfg.Socks, 5*time.Second)
+	p.SetRaceConfig(cfg.Race)
+	p.SetAffinity(cfg.Affinity)
+
+	l := &Listener{
+		addr:  cfg.Listen,
+		ln:    ln,
+		pool:  p,
+		conns: make(map[uint64]*connRecord),
+	}
+	l.state.Store(state)
+	return l, nil
+}
+
+func newState(cfg *config.ListenerConfig) (*listenerState, error) {
+	state := &listenerState{cfg: cfg}
+
+	access, err := newAccessList(cfg.Access)
+	if err != nil {
+		return nil, fmt.Errorf("load access list: %w", err)
+	}
+	state.access = access
+
+	if cfg.Auth != nil {
+		store, err := auth.New(cfg.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("load auth: %w", err)
+		}
+		state.auth = store
+	}
+
+	destPolicy, err := policy.New(cfg.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("load destination policy: %w", err)
+	}
+	state.policy = destPolicy
+
+	router, err := route.New(cfg.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("load rules: %w", err)
+	}
+	state.router = router
+
+	return state, nil
+}
+
+func (l *Listener) config() *config.ListenerConfig {
+	return l.state.Load().cfg
+}
+
+func (l *Listener) Reload(cfg *config.ListenerConfig) error {
+	state, err := newState(cfg)
+	if err != nil {
+		return err
+	}
+
+	l.pool.SetUpstreams(cfg.Socks)
+	l.pool.SetRaceConfig(cfg.Race)
+	l.pool.SetAffinity(cfg.Affinity)
+	l.state.Store(state)
+
+	l.mu.Lock()
+	if l.ctx != nil {
+		l.startBackground()
+	}
+	l.mu.Unlock()
+
+	logger.Info("reloaded %s with %d upstreams", l.addr, len(cfg.Socks))
+	return nil
+}
+
+func (l *Listener) startBackground() {
+	if l.bgCancel != nil {
+		l.bgCancel()
+	}
+
+	ctx, cancel := context.WithCancel(l.ctx)
+	l.bgCancel = cancel
+
+	cfg := l.config()
+	go l.pool.RunStatsLogger(ctx, time.Duration(cfg.StatsLog))
+
+	if cfg.HealthCheck != nil {
+		go l.pool.RunHealthChecks(ctx, cfg.HealthCheck)
+	}
+
+	l.startWarmPool(cfg.WarmPool)
+}
+
+func (l *Listener) startWarmPool(cfg *config.WarmPoolConfig) {
+	if l.warmCancel != nil && reflect.DeepEqual(l.warmCfg, cfg) {
+		return
+	}
+
+	if l.warmCancel != nil {
+		l.warmCancel()
+		l.warmCancel = nil
+	}
+	l.warmCfg = cfg
+
+	if cfg == nil {
+		return
+	}
 
-	return &Listener{
-		cfg:  cfg,
-		ln:   ln,
-		pool: p,
-	}, nil
+	ctx, cancel := context.WithCancel(l.ctx)
+	l.warmCancel = cancel
+	go l.pool.RunWarmPool(ctx, cfg)
+}
+
+func (l *Listener) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stop != nil {
+		l.stop()
+	}
+	l.ln.Close()
 }
 
 func (l *Listener) Serve(ctx context.Context) error {
 	defer l.ln.Close()
 
+	listenerCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	l.mu.Lock()
+	l.ctx = listenerCtx
+	l.stop = stop
+	l.startBackground()
+	l.mu.Unlock()
+
 	go func() {
-		<-ctx.Done()
+		<-listenerCtx.Done()
 		l.ln.Close()
 	}()
 
-	logger.Info("listening on %s with %d upstreams", l.cfg.Listen, len(l.cfg.Socks))
+	logger.Info("listening on %s with %d upstreams", l.addr, len(l.config().Socks))
 
 	for {
 		conn, err := l.ln.Accept()
 		if err != nil {
-			select {
-			case <-ctx.Done():
+			if listenerCtx.Err() != nil || errors.Is(err, net.ErrClosed) {
 				l.wg.Wait()
 				return nil
-			default:
-				logger.Info("accept error: %v", err)
-				continue
 			}
+			logger.Error("accept error: %v", err)
+			continue
 		}
 
-		logger.Info("accepted connection from %s", conn.RemoteAddr())
+		if !l.state.Load().access.permits(conn.RemoteAddr()) {
+			logger.Warn("denied connection from %s by access list", conn.RemoteAddr())
+			metrics.ClientConnectionsDenied.With(l.addr).Inc()
+			conn.Close()
+			continue
+		}
+
+		id := connIDs.Add(1)
+		connLog := logger.With("conn_id", id, "client", conn.RemoteAddr().String(), "listener", l.addr)
+		connLog.Info("accepted connection from %s", conn.RemoteAddr())
+		connCtx := logger.NewContext(ctx, connLog)
+
+		metrics.ClientConnectionsTotal.With(l.addr).Inc()
+		active := metrics.ClientConnectionsActive.With(l.addr)
+		active.Inc()
 
 		l.wg.Add(1)
 		go func() {
 			defer l.wg.Done()
-			handleConnection(ctx, conn, l.pool)
+			defer active.Dec()
+			l.handleConnection(connCtx, conn, id)
 		}()
 	}
 }
+
+func (l *Listener) Addr() string {
+	return l.addr
+}
+
+func (l *Listener) Protocol() string {
+	return l.config().Protocol
+}
+
+func (l *Listener) Pool() *pool.Pool {
+	return l.pool
+}
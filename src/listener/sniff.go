@@ -0,0 +1,39 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"net"
+
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/socks4"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (l *Listener) handleMixed(ctx context.Context, clientConn net.Conn) {
+	br := bufio.NewReader(clientConn)
+	first, err := br.Peek(1)
+	if err != nil {
+		logger.FromContext(ctx).Debug("sniff protocol from %s failed: %v", clientConn.RemoteAddr(), err)
+		return
+	}
+
+	conn := &bufferedConn{Conn: clientConn, r: br}
+	switch first[0] {
+	case socks5.Version5:
+		l.handleSOCKS5(ctx, conn)
+	case socks4.Version4:
+		l.handleSOCKS4(ctx, conn)
+	default:
+		l.handleHTTP(ctx, conn)
+	}
+}
@@ -0,0 +1,98 @@
+package listener
+
+import (
+	"context"
+	"net"
+	"slices"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/metrics"
+	"github.com/bdim404/parallel-socks/src/socks4"
+)
+
+func (l *Listener) handleSOCKS4(ctx context.Context, clientConn net.Conn) {
+	log := logger.FromContext(ctx)
+
+	cmd, target, userID, err := socks4.ParseRequest(clientConn)
+	if err != nil {
+		log.Warn("parse socks4 request failed: %v", err)
+		metrics.RequestParseFailures.With(l.addr).Inc()
+		recordFrom(ctx).fail(reasonHandshakeFailed)
+		socks4.SendReply(clientConn, socks4.RepRejected, nil)
+		return
+	}
+
+	if userID != "" {
+		log.Info("socks4 request from %s with user id %s", clientConn.RemoteAddr(), userID)
+		ctx = withUser(ctx, userID)
+	}
+	ctx = withTarget(ctx, target)
+	log = logger.FromContext(ctx)
+
+	if !l.allowSOCKS4(userID) {
+		log.Warn("socks4 request from %s rejected: user id %q not allowed", clientConn.RemoteAddr(), userID)
+		metrics.NegotiationFailures.With(l.addr).Inc()
+		recordFrom(ctx).fail(reasonHandshakeFailed)
+		socks4.SendReply(clientConn, socks4.RepUserIDMismatch, nil)
+		return
+	}
+
+	if cmd != socks4.CmdConnect {
+		log.Info("socks4 bind from %s rejected: bind is only supported over socks5", clientConn.RemoteAddr())
+		recordFrom(ctx).fail(reasonRejected)
+		socks4.SendReply(clientConn, socks4.RepRejected, nil)
+		return
+	}
+
+	cfg := l.config()
+	deferReply := cfg.DeferReply == nil || *cfg.DeferReply
+
+	if err := l.checkDestination(ctx, clientConn, target); err != nil {
+		socks4.SendReply(clientConn, socks4.RepRejected, nil)
+		return
+	}
+
+	decision := l.matchRoute(ctx, target)
+	if decision.Action == config.RuleActionReject {
+		socks4.SendReply(clientConn, socks4.RepRejected, nil)
+		return
+	}
+
+	if !deferReply {
+		if err := socks4.SendReply(clientConn, socks4.RepGranted, clientConn.LocalAddr()); err != nil {
+			log.Info("send reply failed: %v", err)
+			return
+		}
+	}
+
+	upstreamConn, err := l.dial(ctx, target, decision)
+	if err != nil {
+		if deferReply {
+			socks4.SendReply(clientConn, socks4.RepRejected, nil)
+		}
+		return
+	}
+	defer upstreamConn.Close()
+
+	if deferReply {
+		bindAddr := upstreamConn.BindAddr
+		if bindAddr == nil {
+			bindAddr = clientConn.LocalAddr()
+		}
+		if err := socks4.SendReply(clientConn, socks4.RepGranted, bindAddr); err != nil {
+			log.Info("send reply failed: %v", err)
+			return
+		}
+	}
+
+	l.relay(ctx, clientConn, upstreamConn)
+}
+
+func (l *Listener) allowSOCKS4(userID string) bool {
+	state := l.state.Load()
+	if state.cfg.Socks4 != nil && len(state.cfg.Socks4.UserIDs) > 0 {
+		return slices.Contains(state.cfg.Socks4.UserIDs, userID)
+	}
+	return state.auth == nil
+}
@@ -0,0 +1,138 @@
+package listener
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/pool"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+func (l *Listener) handleUDPAssociate(ctx context.Context, clientConn net.Conn, target *socks5.TargetAddress) {
+	log := logger.FromContext(ctx)
+	rec := recordFrom(ctx)
+
+	cfg := l.config()
+	if cfg.UDP == nil {
+		log.Info("udp associate from %s rejected: udp is not enabled", clientConn.RemoteAddr())
+		rec.fail(reasonRejected)
+		socks5.SendReply(clientConn, socks5.RepCommandNotSupported, nil)
+		return
+	}
+
+	localAddr := clientConn.LocalAddr().(*net.TCPAddr)
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localAddr.IP, Zone: localAddr.Zone})
+	if err != nil {
+		log.Info("udp listen failed: %v", err)
+		socks5.SendReply(clientConn, socks5.RepGeneralFailure, nil)
+		return
+	}
+	defer udpConn.Close()
+
+	start := time.Now()
+	session, err := l.pool.OpenUDP(ctx, cfg.UDP.PerDatagramPorts)
+	if err != nil {
+		rec.fail(reasonUpstreamFailed)
+		socks5.SendReply(clientConn, socks5.ReplyCodeForError(err), nil)
+		return
+	}
+	defer session.Close()
+	rec.setRace(time.Since(start))
+
+	if err := socks5.SendReply(clientConn, socks5.RepSuccess, udpConn.LocalAddr()); err != nil {
+		log.Info("send reply failed: %v", err)
+		return
+	}
+
+	log.Info("udp associate for %s relaying on %s", clientConn.RemoteAddr(), udpConn.LocalAddr())
+
+	clientIP := clientConn.RemoteAddr().(*net.TCPAddr).IP
+	destPolicy := l.state.Load().policy
+	var clientAddr atomic.Pointer[net.UDPAddr]
+	var denied atomic.Uint64
+
+	done := make(chan string, 3)
+
+	go func() {
+		buf := make([]byte, socks5.MaxUDPDatagramSize)
+		for {
+			n, src, err := udpConn.ReadFromUDP(buf)
+			if err != nil {
+				done <- reasonClientError
+				return
+			}
+
+			if !src.IP.Equal(clientIP) || (target.Port != 0 && src.Port != int(target.Port)) {
+				log.Debug("udp: dropping datagram from unexpected source %s", src)
+				continue
+			}
+			clientAddr.Store(src)
+
+			dst, data, err := socks5.ParseUDPDatagram(buf[:n])
+			if err != nil {
+				log.Debug("udp: dropping datagram from %s: %v", src, err)
+				continue
+			}
+
+			if err := destPolicy.Check(dst); err != nil {
+				log.Debug("udp: dropping datagram from %s: %v", src, err)
+				denied.Add(1)
+				continue
+			}
+
+			if err := session.WriteTo(data, dst); err != nil {
+				log.Debug("udp: %v", err)
+				continue
+			}
+			rec.up.Add(uint64(len(data)))
+		}
+	}()
+
+	go func() {
+		for {
+			pkt, err := session.ReadFrom()
+			if err != nil {
+				done <- reasonUpstreamClosed
+				return
+			}
+
+			addr := clientAddr.Load()
+			if addr == nil {
+				continue
+			}
+
+			datagram, err := socks5.AppendUDPDatagram(make([]byte, 0, len(pkt.Data)+262), pkt.Source, pkt.Data)
+			if err != nil {
+				log.Debug("udp: build datagram from %s: %v", pkt.Source, err)
+				continue
+			}
+
+			if _, err := udpConn.WriteToUDP(datagram, addr); err != nil {
+				done <- reasonClientError
+				return
+			}
+			rec.down.Add(uint64(len(pkt.Data)))
+			rec.addUpstream(pool.UpstreamLabel(pkt.Upstream))
+		}
+	}()
+
+	go func() {
+		_, err := io.Copy(io.Discard, clientConn)
+		done <- closeReason(true, err)
+	}()
+
+	reason := reasonShutdown
+	select {
+	case reason = <-done:
+	case <-ctx.Done():
+	}
+
+	if rec.up.Load() == 0 && denied.Load() > 0 {
+		reason = reasonDenied
+	}
+	rec.fail(reason)
+}
@@ -1,30 +1,240 @@
 package logger
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
 )
 
 const (
+	LevelTrace = "trace"
 	LevelDebug = "debug"
 	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+const slogLevelTrace = slog.Level(-8)
+
+var (
+	level   slog.LevelVar
+	handler atomic.Pointer[slog.Handler]
+	root    = &Logger{}
+
+	accessMu   sync.RWMutex
+	accessFile *os.File
+	access     atomic.Pointer[accessSink]
 )
 
-var currentLevel = LevelInfo
+type accessSink struct {
+	h slog.Handler
+}
 
-func SetLevel(level string) {
-	currentLevel = level
+type Logger struct {
+	attrs []any
 }
 
-func Debug(format string, v ...interface{}) {
-	if currentLevel == LevelDebug {
-		log.Printf(format, v...)
+type contextKey struct{}
+
+func init() {
+	SetFormat(FormatText)
+}
+
+func SetLevel(name string) {
+	switch name {
+	case LevelTrace:
+		level.Set(slogLevelTrace)
+	case LevelDebug:
+		level.Set(slog.LevelDebug)
+	case LevelWarn:
+		level.Set(slog.LevelWarn)
+	case LevelError:
+		level.Set(slog.LevelError)
+	default:
+		level.Set(slog.LevelInfo)
+	}
+}
+
+func SetFormat(format string) {
+	h := newHandler(log.Default(), os.Stderr, format, &level)
+	handler.Store(&h)
+}
+
+func newHandler(std *log.Logger, w io.Writer, format string, lvl slog.Leveler) slog.Handler {
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: replaceLevel}
+
+	switch format {
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts)
+	case FormatLogfmt:
+		return slog.NewTextHandler(w, opts)
+	default:
+		return textHandler{out: std, level: lvl}
+	}
+}
+
+func SetAccessLog(enabled bool, path, format string) error {
+	accessMu.Lock()
+	defer accessMu.Unlock()
+
+	var file *os.File
+	var sink *accessSink
+	switch {
+	case !enabled:
+	case path != "":
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open access log: %w", err)
+		}
+		file = f
+		sink = &accessSink{h: newHandler(log.New(f, "", log.LstdFlags), f, format, slog.LevelInfo)}
+	case format != "":
+		sink = &accessSink{h: newHandler(log.Default(), os.Stderr, format, slog.LevelInfo)}
+	default:
+		sink = &accessSink{}
 	}
+
+	access.Store(sink)
+	if accessFile != nil {
+		accessFile.Close()
+	}
+	accessFile = file
+	return nil
+}
+
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if l, ok := a.Value.Any().(slog.Level); ok && l <= slogLevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+type textHandler struct {
+	out   *log.Logger
+	level slog.Leveler
+}
+
+func (h textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h textHandler) Handle(_ context.Context, r slog.Record) error {
+	h.out.Print(r.Message)
+	return nil
+}
+
+func (h textHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h textHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func With(args ...any) *Logger {
+	return root.With(args...)
+}
+
+func (l *Logger) With(args ...any) *Logger {
+	attrs := make([]any, 0, len(l.attrs)+len(args))
+	attrs = append(attrs, l.attrs...)
+	attrs = append(attrs, args...)
+	return &Logger{attrs: attrs}
+}
+
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return root
+}
+
+func (l *Logger) log(lvl slog.Level, format string, v []any) {
+	l.logTo(*handler.Load(), lvl, format, v)
+}
+
+func (l *Logger) logTo(h slog.Handler, lvl slog.Level, format string, v []any) {
+	if !h.Enabled(context.Background(), lvl) {
+		return
+	}
+	slog.New(h).Log(context.Background(), lvl, fmt.Sprintf(format, v...), l.attrs...)
+}
+
+func (l *Logger) Trace(format string, v ...any) {
+	l.log(slogLevelTrace, format, v)
+}
+
+func (l *Logger) Debug(format string, v ...any) {
+	l.log(slog.LevelDebug, format, v)
+}
+
+func (l *Logger) Info(format string, v ...any) {
+	l.log(slog.LevelInfo, format, v)
+}
+
+func (l *Logger) Warn(format string, v ...any) {
+	l.log(slog.LevelWarn, format, v)
+}
+
+func (l *Logger) Error(format string, v ...any) {
+	l.log(slog.LevelError, format, v)
+}
+
+func AccessEnabled() bool {
+	return access.Load() != nil
+}
+
+func (l *Logger) Access(format string, v ...any) {
+	accessMu.RLock()
+	defer accessMu.RUnlock()
+
+	sink := access.Load()
+	if sink == nil {
+		return
+	}
+	if sink.h == nil {
+		l.log(slog.LevelInfo, format, v)
+		return
+	}
+	l.logTo(sink.h, slog.LevelInfo, format, v)
+}
+
+func Trace(format string, v ...any) {
+	root.log(slogLevelTrace, format, v)
+}
+
+func Debug(format string, v ...any) {
+	root.log(slog.LevelDebug, format, v)
+}
+
+func Info(format string, v ...any) {
+	root.log(slog.LevelInfo, format, v)
+}
+
+func Warn(format string, v ...any) {
+	root.log(slog.LevelWarn, format, v)
 }
 
-func Info(format string, v ...interface{}) {
-	log.Printf(format, v...)
+func Error(format string, v ...any) {
+	root.log(slog.LevelError, format, v)
 }
 
-func Fatal(format string, v ...interface{}) {
-	log.Fatalf(format, v...)
+func Fatal(format string, v ...any) {
+	root.log(slog.LevelError, format, v)
+	os.Exit(1)
 }
@@ -4,12 +4,11 @@ import (
 	"context"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/bdim404/parallel-socks/src/cmd"
 	"github.com/bdim404/parallel-socks/src/config"
-	"github.com/bdim404/parallel-socks/src/listener"
 	"github.com/bdim404/parallel-socks/src/logger"
 )
 
@@ -29,42 +28,37 @@ func main() {
 		}
 	}
 
-	if flags.LogLevel != "" {
-		cfg.LogLevel = flags.LogLevel
-	}
-
-	if err := cfg.Validate(); err != nil {
-		logger.Fatal("validate config: %v", err)
-	}
-
-	logger.SetLevel(cfg.LogLevel)
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	srv := newServer(ctx, flags.LogLevel, flags.LogFormat)
+	if err := srv.apply(cfg); err != nil {
+		logger.Fatal("%v", err)
+	}
 
-	var wg sync.WaitGroup
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	for _, listenerCfg := range cfg.Listeners {
-		l, err := listener.New(&listenerCfg)
-		if err != nil {
-			logger.Fatal("create listener for %s: %v", listenerCfg.Listen, err)
-		}
+	reloadCh := make(chan struct{}, 1)
+	if flags.WatchConfig && flags.ConfigPath != "" {
+		go watchConfig(ctx, flags.ConfigPath, 2*time.Second, reloadCh)
+	}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := l.Serve(ctx); err != nil {
-				logger.Info("listener error: %v", err)
+loop:
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig != syscall.SIGHUP {
+				break loop
 			}
-		}()
+			srv.reload(flags.ConfigPath)
+		case <-reloadCh:
+			srv.reload(flags.ConfigPath)
+		}
 	}
 
-	<-sigCh
 	logger.Info("shutting down...")
 	cancel()
-	wg.Wait()
+	srv.wg.Wait()
 	logger.Info("shutdown complete")
 }
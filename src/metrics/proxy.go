@@ -0,0 +1,51 @@
+package metrics
+
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	ClientConnectionsActive = NewGaugeVec(
+		"parallel_socks_client_connections_active",
+		"Client connections currently open.",
+		"listener")
+
+	ClientConnectionsTotal = NewCounterVec(
+		"parallel_socks_client_connections_total",
+		"Client connections accepted.",
+		"listener")
+
+	ClientConnectionsDenied = NewCounterVec(
+		"parallel_socks_client_connections_denied_total",
+		"Client connections closed by the listener access list.",
+		"listener")
+
+	NegotiationFailures = NewCounterVec(
+		"parallel_socks_negotiation_failures_total",
+		"Client connections that failed SOCKS5 method negotiation or proxy authentication.",
+		"listener")
+
+	RequestParseFailures = NewCounterVec(
+		"parallel_socks_request_parse_failures_total",
+		"Client SOCKS5 or HTTP proxy requests that could not be parsed.",
+		"listener")
+
+	RaceOutcomes = NewCounterVec(
+		"parallel_socks_race_outcomes_total",
+		"Race results per upstream by outcome (win, loss, failure) and failure class.",
+		"listener", "upstream", "outcome", "class")
+
+	RaceDuration = NewHistogramVec(
+		"parallel_socks_race_handshake_duration_seconds",
+		"Time for an upstream to complete its handshake, for winners and losers that completed.",
+		durationBuckets,
+		"listener", "upstream")
+
+	RaceTimeouts = NewCounterVec(
+		"parallel_socks_race_timeouts_total",
+		"Races in which no upstream succeeded before the race timeout.",
+		"listener")
+
+	BytesTransferred = NewCounterVec(
+		"parallel_socks_bytes_total",
+		"Bytes relayed between clients and upstreams; direction is up (client to upstream) or down.",
+		"listener", "upstream", "direction")
+)
@@ -0,0 +1,129 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+type Policy struct {
+	loopback  bool
+	linkLocal bool
+	private   bool
+	nets      []*net.IPNet
+	domains   []string
+	ports     []portRange
+}
+
+type portRange struct {
+	first uint16
+	last  uint16
+}
+
+type contextKey struct{}
+
+func New(cfg *config.DestinationPolicyConfig) (*Policy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	p := &Policy{
+		loopback:  cfg.DenyLoopback,
+		linkLocal: cfg.DenyLinkLocal,
+		private:   cfg.DenyPrivate,
+	}
+	for _, cidr := range cfg.DenyCIDRs {
+		ipNet, err := config.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		p.nets = append(p.nets, ipNet)
+	}
+	for _, domain := range cfg.DenyDomains {
+		p.domains = append(p.domains, strings.TrimPrefix(normalizeHost(domain), "."))
+	}
+	for _, ports := range cfg.DenyPorts {
+		first, last, err := config.ParsePortRange(ports)
+		if err != nil {
+			return nil, err
+		}
+		p.ports = append(p.ports, portRange{first: first, last: last})
+	}
+	return p, nil
+}
+
+func (p *Policy) Check(target *socks5.TargetAddress) error {
+	if p == nil {
+		return nil
+	}
+
+	for _, pr := range p.ports {
+		if target.Port >= pr.first && target.Port <= pr.last {
+			return denied(target.String(), "port")
+		}
+	}
+
+	host := normalizeHost(target.Host)
+	if ip := config.ParseHostIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+
+	if p.loopback && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return denied(host, "loopback")
+	}
+	for _, domain := range p.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return denied(host, "domain")
+		}
+	}
+	return nil
+}
+
+func (p *Policy) CheckIP(ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+
+	switch {
+	case p.loopback && (ip.IsLoopback() || ip.IsUnspecified()):
+		return denied(ip.String(), "loopback")
+	case p.linkLocal && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()):
+		return denied(ip.String(), "link-local")
+	case p.private && ip.IsPrivate():
+		return denied(ip.String(), "private")
+	}
+
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return denied(ip.String(), "cidr")
+		}
+	}
+	return nil
+}
+
+func NewContext(ctx context.Context, p *Policy) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+func FromContext(ctx context.Context) *Policy {
+	p, _ := ctx.Value(contextKey{}).(*Policy)
+	return p
+}
+
+func denied(dest, reason string) error {
+	return &socks5.SOCKS5Error{
+		ReplyCode: socks5.RepConnectionNotAllowed,
+		Message:   fmt.Sprintf("destination %s denied by policy (%s)", dest, reason),
+	}
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
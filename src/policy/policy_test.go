@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+func TestCheck(t *testing.T) {
+	p, err := New(&config.DestinationPolicyConfig{
+		DenyLoopback:  true,
+		DenyLinkLocal: true,
+		DenyPrivate:   true,
+		DenyDomains:   []string{"internal.example"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host   string
+		denied bool
+	}{
+		{"127.0.0.1", true},
+		{"127.0.0.1.", true},
+		{"169.254.169.254.", true},
+		{"10.0.0.1.", true},
+		{"127.1", true},
+		{"2130706433", true},
+		{"0x7f.1", true},
+		{"0X7F.0.0.1", true},
+		{"0177.0.0.1", true},
+		{"017700000001", true},
+		{"0xa9.0xfe.0xa9.0xfe", true},
+		{"192.168.1", true},
+		{"0", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"localhost.", true},
+		{"LOCALHOST", true},
+		{"foo.internal.example.", true},
+		{"example.com", false},
+		{"8.8.8.8.", false},
+		{"1.2.3.4.5", false},
+		{"0x7f.1.example", false},
+		{"08.0.0.1", false},
+		{"256.0.0.1", false},
+	}
+	for _, tt := range tests {
+		err := p.Check(&socks5.TargetAddress{Host: tt.host, Port: 80})
+		if (err != nil) != tt.denied {
+			t.Errorf("Check(%q) = %v, want denied %v", tt.host, err, tt.denied)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package pool
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/logger"
+)
+
+type disabledState struct {
+	until atomic.Int64
+}
+
+type UpstreamStatus struct {
+	Name          string         `json:"name,omitempty"`
+	Address       string         `json:"address"`
+	Type          string         `json:"type"`
+	Groups        []string       `json:"groups,omitempty"`
+	Disabled      bool           `json:"disabled"`
+	DisabledUntil *time.Time     `json:"disabled_until,omitempty"`
+	Health        UpstreamHealth `json:"health"`
+	Stats         UpstreamStats  `json:"stats"`
+}
+
+func (d *disabledState) active(now time.Time) bool {
+	until := d.until.Load()
+	return until != 0 && now.UnixNano() < until
+}
+
+func enabled(upstreams []*upstream) []*upstream {
+	now := time.Now()
+	active := make([]*upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		if !u.disabled.active(now) {
+			active = append(active, u)
+		}
+	}
+	return active
+}
+
+func (p *Pool) Status() []UpstreamStatus {
+	now := time.Now()
+	upstreams := p.current()
+	states := make([]UpstreamStatus, len(upstreams))
+	for i, u := range upstreams {
+		states[i] = UpstreamStatus{
+			Name:     u.cfg.Name,
+			Address:  upstreamAddress(u.cfg),
+			Type:     u.cfg.Type,
+			Groups:   u.cfg.Groups,
+			Disabled: u.disabled.active(now),
+			Health:   u.healthSnapshot(),
+			Stats:    u.statsSnapshot(),
+		}
+		if until := u.disabled.until.Load(); states[i].Disabled && until != math.MaxInt64 {
+			t := time.Unix(0, until)
+			states[i].DisabledUntil = &t
+		}
+	}
+	return states
+}
+
+func (p *Pool) Disable(label string, d time.Duration) error {
+	u := p.find(label)
+	if u == nil {
+		return fmt.Errorf("unknown upstream: %s", label)
+	}
+
+	if d <= 0 {
+		u.disabled.until.Store(math.MaxInt64)
+		logger.With("upstream", UpstreamLabel(u.cfg)).Warn("upstream %s disabled", upstreamName(u.cfg))
+		return nil
+	}
+
+	u.disabled.until.Store(time.Now().Add(d).UnixNano())
+	logger.With("upstream", UpstreamLabel(u.cfg)).Warn("upstream %s disabled for %s", upstreamName(u.cfg), d)
+	return nil
+}
+
+func (p *Pool) Enable(label string) error {
+	u := p.find(label)
+	if u == nil {
+		return fmt.Errorf("unknown upstream: %s", label)
+	}
+
+	u.disabled.until.Store(0)
+	logger.With("upstream", UpstreamLabel(u.cfg)).Info("upstream %s enabled", upstreamName(u.cfg))
+	return nil
+}
+
+func (p *Pool) find(label string) *upstream {
+	for _, u := range p.current() {
+		if UpstreamLabel(u.cfg) == label || upstreamAddress(u.cfg) == label {
+			return u
+		}
+	}
+	return nil
+}
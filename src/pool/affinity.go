@@ -0,0 +1,145 @@
+package pool
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+type affinityCache struct {
+	cfg config.AffinityConfig
+
+	mu      sync.Mutex
+	entries map[string]affinityEntry
+}
+
+type affinityEntry struct {
+	u       *upstream
+	expires time.Time
+}
+
+func (p *Pool) SetAffinity(cfg *config.AffinityConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cfg == nil {
+		p.affinity = nil
+		return
+	}
+	if p.affinity != nil && p.affinity.cfg == *cfg {
+		return
+	}
+	p.affinity = &affinityCache{cfg: *cfg, entries: make(map[string]affinityEntry)}
+}
+
+func (p *Pool) affinityCache() *affinityCache {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.affinity
+}
+
+func (c *affinityCache) key(target *socks5.TargetAddress) string {
+	host := strings.TrimSuffix(strings.ToLower(target.Host), ".")
+	if c.cfg.Key == config.AffinityKeyDomain && target.Type == socks5.AtypDomain {
+		return registrableDomain(host)
+	}
+	return host
+}
+
+func (c *affinityCache) lookup(key string, candidates []*upstream) *upstream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	if !slices.Contains(candidates, e.u) {
+		return nil
+	}
+	return e.u
+}
+
+func (c *affinityCache) remember(key string, u *upstream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.cfg.MaxEntries {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.cfg.MaxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = affinityEntry{u: u, expires: time.Now().Add(time.Duration(c.cfg.TTL))}
+}
+
+func (c *affinityCache) forget(key string, u *upstream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok && e.u == u {
+		delete(c.entries, key)
+	}
+}
+
+func (p *Pool) dialSticky(ctx context.Context, target *socks5.TargetAddress, u *upstream) (*Conn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, bindAddr, err := dialConnect(dialCtx, u, target)
+	res := &result{
+		conn:     conn,
+		bindAddr: bindAddr,
+		from:     u,
+		dest:     target.Host,
+		err:      err,
+		duration: time.Since(start),
+	}
+
+	if err != nil {
+		p.recordResult(res, false)
+		return nil, err
+	}
+
+	p.recordResult(res, true)
+	logger.FromContext(ctx).With("upstream", UpstreamLabel(u.cfg), "race_ms", res.duration.Milliseconds()).
+		Info("✓ %s -> %s (%dms, sticky)", target, upstreamName(u.cfg), res.duration.Milliseconds())
+
+	return &Conn{
+		Conn:     conn,
+		Upstream: u.cfg,
+		BindAddr: bindAddr,
+		Duration: res.duration,
+		from:     u,
+	}, nil
+}
+
+func registrableDomain(host string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
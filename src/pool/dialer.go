@@ -0,0 +1,72 @@
+package pool
+
+import (
+	"context"
+	"net"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/httpproxy"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+type upstreamDialer interface {
+	dial(ctx context.Context, target *socks5.TargetAddress) (net.Conn, net.Addr, error)
+	probe(ctx context.Context, target *socks5.TargetAddress) error
+}
+
+type negotiator interface {
+	negotiate(ctx context.Context) (net.Conn, error)
+	connect(ctx context.Context, conn net.Conn, target *socks5.TargetAddress) (net.Addr, error)
+}
+
+func newDialer(cfg config.UpstreamConfig) upstreamDialer {
+	switch cfg.Type {
+	case config.UpstreamTypeDirect:
+		return &directDialer{cfg: cfg}
+	case config.UpstreamTypeHTTP:
+		return &httpDialer{cfg: cfg}
+	default:
+		return &socks5Dialer{cfg: cfg}
+	}
+}
+
+type socks5Dialer struct {
+	cfg config.UpstreamConfig
+}
+
+func (d *socks5Dialer) dial(ctx context.Context, target *socks5.TargetAddress) (net.Conn, net.Addr, error) {
+	return socks5.DialSOCKS5(ctx, d.cfg.Address, credentials(d.cfg), target)
+}
+
+func (d *socks5Dialer) probe(ctx context.Context, target *socks5.TargetAddress) error {
+	return socks5.Probe(ctx, d.cfg.Address, credentials(d.cfg), target)
+}
+
+func (d *socks5Dialer) negotiate(ctx context.Context) (net.Conn, error) {
+	return socks5.DialNegotiated(ctx, d.cfg.Address, credentials(d.cfg))
+}
+
+func (d *socks5Dialer) connect(ctx context.Context, conn net.Conn, target *socks5.TargetAddress) (net.Addr, error) {
+	return socks5.Connect(ctx, conn, target)
+}
+
+type httpDialer struct {
+	cfg config.UpstreamConfig
+}
+
+func (d *httpDialer) dial(ctx context.Context, target *socks5.TargetAddress) (net.Conn, net.Addr, error) {
+	conn, err := httpproxy.DialConnect(ctx, d.cfg.Address, credentials(d.cfg), target)
+	return conn, nil, err
+}
+
+func (d *httpDialer) probe(ctx context.Context, target *socks5.TargetAddress) error {
+	return httpproxy.Probe(ctx, d.cfg.Address, credentials(d.cfg), target)
+}
+
+func (d *httpDialer) negotiate(ctx context.Context) (net.Conn, error) {
+	return httpproxy.DialNegotiated(ctx, d.cfg.Address)
+}
+
+func (d *httpDialer) connect(ctx context.Context, conn net.Conn, target *socks5.TargetAddress) (net.Addr, error) {
+	return nil, httpproxy.Connect(ctx, conn, credentials(d.cfg), target)
+}
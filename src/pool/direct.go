@@ -0,0 +1,81 @@
+package pool
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/policy"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+var directUpstream = config.UpstreamConfig{Type: config.UpstreamTypeDirect}
+
+type directDialer struct {
+	cfg config.UpstreamConfig
+}
+
+func (d *directDialer) dial(ctx context.Context, target *socks5.TargetAddress) (net.Conn, net.Addr, error) {
+	nd := &net.Dialer{ControlContext: d.control}
+	if d.cfg.LocalAddress != "" {
+		nd.LocalAddr = &net.TCPAddr{IP: net.ParseIP(d.cfg.LocalAddress)}
+	}
+
+	conn, err := nd.DialContext(ctx, "tcp", target.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, conn.LocalAddr(), nil
+}
+
+func (d *directDialer) control(ctx context.Context, network, address string, c syscall.RawConn) error {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if err := policy.FromContext(ctx).CheckIP(net.ParseIP(host)); err != nil {
+			return err
+		}
+	}
+
+	if d.cfg.Interface != "" {
+		return bindToInterface(d.cfg.Interface)(network, address, c)
+	}
+	return nil
+}
+
+func (d *directDialer) probe(ctx context.Context, target *socks5.TargetAddress) error {
+	if target == nil {
+		return nil
+	}
+
+	conn, _, err := d.dial(ctx, target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *Pool) DialDirect(ctx context.Context, target *socks5.TargetAddress) (*Conn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	d := &directDialer{cfg: directUpstream}
+	conn, bindAddr, err := d.dial(dialCtx, target)
+	if err != nil {
+		logger.FromContext(ctx).Warn("✗ %s direct dial failed: %v", target, err)
+		return nil, err
+	}
+	duration := time.Since(start)
+
+	logger.FromContext(ctx).With("upstream", UpstreamLabel(directUpstream), "race_ms", duration.Milliseconds()).
+		Info("✓ %s -> direct (%dms)", target, duration.Milliseconds())
+
+	return &Conn{
+		Conn:     conn,
+		Upstream: directUpstream,
+		BindAddr: bindAddr,
+		Duration: duration,
+	}, nil
+}
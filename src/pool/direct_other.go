@@ -0,0 +1,14 @@
+//go:build !linux
+
+package pool
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func bindToInterface(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("binding to interface %s is not supported on this platform", name)
+	}
+}
@@ -0,0 +1,170 @@
+package pool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+type healthState struct {
+	healthy atomic.Bool
+
+	mu        sync.Mutex
+	failures  int
+	backoff   time.Duration
+	lastCheck time.Time
+	nextCheck time.Time
+	lastError string
+}
+
+type UpstreamHealth struct {
+	Name                string    `json:"name,omitempty"`
+	Address             string    `json:"address"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastCheck           time.Time `json:"last_check,omitempty"`
+	NextCheck           time.Time `json:"next_check,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+func (p *Pool) Health() []UpstreamHealth {
+	upstreams := p.current()
+	states := make([]UpstreamHealth, len(upstreams))
+	for i, u := range upstreams {
+		states[i] = u.healthSnapshot()
+	}
+	return states
+}
+
+func (u *upstream) healthSnapshot() UpstreamHealth {
+	u.health.mu.Lock()
+	defer u.health.mu.Unlock()
+	return UpstreamHealth{
+		Name:                u.cfg.Name,
+		Address:             upstreamAddress(u.cfg),
+		Healthy:             u.health.healthy.Load(),
+		ConsecutiveFailures: u.health.failures,
+		LastCheck:           u.health.lastCheck,
+		NextCheck:           u.health.nextCheck,
+		LastError:           u.health.lastError,
+	}
+}
+
+func (p *Pool) RunHealthChecks(ctx context.Context, cfg *config.HealthCheckConfig) {
+	var probeTarget *socks5.TargetAddress
+	if cfg.ProbeTarget != "" {
+		target, err := socks5.ParseTargetAddress(cfg.ProbeTarget)
+		if err != nil {
+			logger.Error("health check disabled: invalid probe target: %v", err)
+			return
+		}
+		probeTarget = target
+	}
+
+	running := make(map[*upstream]context.CancelFunc)
+	defer func() {
+		for _, cancel := range running {
+			cancel()
+		}
+	}()
+
+	for {
+		upstreams, changed := p.watch()
+		present := make(map[*upstream]bool)
+		for _, u := range upstreams {
+			present[u] = true
+			if _, ok := running[u]; ok {
+				continue
+			}
+			loopCtx, cancel := context.WithCancel(ctx)
+			running[u] = cancel
+			go p.healthLoop(loopCtx, u, cfg, probeTarget)
+		}
+
+		for u, cancel := range running {
+			if !present[u] {
+				cancel()
+				delete(running, u)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (p *Pool) healthLoop(ctx context.Context, u *upstream, cfg *config.HealthCheckConfig, probeTarget *socks5.TargetAddress) {
+	interval := time.Duration(cfg.Interval)
+
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+		err := u.dialer.probe(probeCtx, probeTarget)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := u.health.record(u.cfg, err, cfg.FailureThreshold, interval, time.Duration(cfg.MaxBackoff))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (h *healthState) record(u config.UpstreamConfig, err error, threshold int, interval, maxBackoff time.Duration) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.lastCheck = now
+
+	if err == nil {
+		if !h.healthy.Load() {
+			logger.With("upstream", UpstreamLabel(u)).Info("✓ upstream %s re-admitted after health check", upstreamName(u))
+		}
+		h.healthy.Store(true)
+		h.failures = 0
+		h.backoff = 0
+		h.lastError = ""
+		h.nextCheck = now.Add(interval)
+		return interval
+	}
+
+	h.failures++
+	h.lastError = err.Error()
+	logger.With("upstream", UpstreamLabel(u)).Debug("health check for %s failed (%d consecutive): %v", upstreamName(u), h.failures, err)
+
+	if h.failures < threshold {
+		h.nextCheck = now.Add(interval)
+		return interval
+	}
+
+	if h.healthy.Load() {
+		h.healthy.Store(false)
+		h.backoff = interval
+		logger.With("upstream", UpstreamLabel(u)).Warn("✗ upstream %s ejected after %d failed health checks: %v (retry in %s)", upstreamName(u), h.failures, err, h.backoff)
+	} else {
+		h.backoff *= 2
+		if h.backoff > maxBackoff {
+			h.backoff = maxBackoff
+		}
+		logger.With("upstream", UpstreamLabel(u)).Debug("upstream %s still unhealthy, next check in %s", upstreamName(u), h.backoff)
+	}
+
+	h.nextCheck = now.Add(h.backoff)
+	return h.backoff
+}
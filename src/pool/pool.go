@@ -2,8 +2,11 @@ package pool
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/bdim404/parallel-socks/src/config"
@@ -12,108 +15,271 @@ import (
 )
 
 type Pool struct {
-	upstreams []config.UpstreamConfig
-	timeout   time.Duration
+	name    string
+	timeout time.Duration
+
+	mu        sync.RWMutex
+	upstreams []*upstream
+	raceCfg   config.RaceConfig
+	affinity  *affinityCache
+	changed   chan struct{}
+}
+
+type upstream struct {
+	cfg      config.UpstreamConfig
+	dialer   upstreamDialer
+	health   healthState
+	stats    upstreamStats
+	warm     warmPool
+	disabled disabledState
 }
 
-func New(upstreams []config.UpstreamConfig, raceTimeout time.Duration) *Pool {
-	return &Pool{
-		upstreams: upstreams,
+func New(name string, upstreams []config.UpstreamConfig, raceTimeout time.Duration) *Pool {
+	p := &Pool{
+		name:      name,
+		upstreams: make([]*upstream, len(upstreams)),
 		timeout:   raceTimeout,
+		raceCfg:   config.RaceConfig{Strategy: config.RaceStrategyAll},
+		changed:   make(chan struct{}),
 	}
+	for i, cfg := range upstreams {
+		p.upstreams[i] = newUpstream(cfg)
+	}
+	return p
 }
 
-type result struct {
-	conn      net.Conn
-	upstream  config.UpstreamConfig
-	err       error
-	duration  time.Duration
-}
-
-func (p *Pool) GetConn(ctx context.Context, target *socks5.TargetAddress) (net.Conn, config.UpstreamConfig, error) {
-	return p.race(ctx, target)
-}
-
-func (p *Pool) race(ctx context.Context, target *socks5.TargetAddress) (net.Conn, config.UpstreamConfig, error) {
-	raceCtx, cancel := context.WithTimeout(ctx, p.timeout)
-	defer cancel()
-
-	resultCh := make(chan *result, len(p.upstreams))
-	raceStartTime := time.Now()
-
-	for _, upstream := range p.upstreams {
-		go func(u config.UpstreamConfig) {
-			start := time.Now()
-			conn, err := socks5.DialSOCKS5(raceCtx, u.Address, target)
-			duration := time.Since(start)
-
-			select {
-			case resultCh <- &result{
-				conn:     conn,
-				upstream: u,
-				err:      err,
-				duration: duration,
-			}:
-			case <-raceCtx.Done():
-				if conn != nil {
-					conn.Close()
-				}
+func (p *Pool) SetUpstreams(upstreams []config.UpstreamConfig) {
+	p.mu.Lock()
+	existing := p.upstreams
+	updated := make([]*upstream, len(upstreams))
+	for i, cfg := range upstreams {
+		for _, u := range existing {
+			if reflect.DeepEqual(u.cfg, cfg) {
+				updated[i] = u
+				break
 			}
-		}(upstream)
+		}
+		if updated[i] == nil {
+			updated[i] = newUpstream(cfg)
+		}
+	}
+	p.upstreams = updated
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+}
+
+func (p *Pool) SetRaceConfig(cfg *config.RaceConfig) {
+	if cfg == nil {
+		return
 	}
 
-	var winnerConn net.Conn
-	var winnerUpstream config.UpstreamConfig
-	var winnerDuration time.Duration
-	var firstSOCKS5Error *socks5.SOCKS5Error
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.raceCfg = *cfg
+}
 
-	for i := 0; i < len(p.upstreams); i++ {
-		select {
-		case res := <-resultCh:
-			if res.err == nil && res.conn != nil && winnerConn == nil {
-				winnerConn = res.conn
-				winnerUpstream = res.upstream
-				winnerDuration = res.duration
+func (p *Pool) raceConfig() config.RaceConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.raceCfg
+}
 
-				winnerName := winnerUpstream.Address
-				if winnerUpstream.Name != "" {
-					winnerName = fmt.Sprintf("%s (%s)", winnerUpstream.Name, winnerUpstream.Address)
-				}
-				logger.Info("✓ %s -> %s (%dms)", target, winnerName, winnerDuration.Milliseconds())
+func (p *Pool) current() []*upstream {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.upstreams
+}
 
-				go p.collectRaceStats(resultCh, len(p.upstreams)-i-1)
+func (p *Pool) watch() ([]*upstream, <-chan struct{}) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.upstreams, p.changed
+}
 
-				return winnerConn, winnerUpstream, nil
-			}
+func newUpstream(cfg config.UpstreamConfig) *upstream {
+	u := &upstream{cfg: cfg, dialer: newDialer(cfg)}
+	u.health.healthy.Store(true)
+	u.warm.refill = make(chan struct{}, 1)
+	return u
+}
 
-			if winnerConn != nil && res.conn != nil {
-				res.conn.Close()
+func (p *Pool) candidates(group string, socksOnly bool) []*upstream {
+	upstreams := enabled(p.current())
+	if group != "" {
+		upstreams = inGroup(upstreams, group)
+	}
+	if socksOnly {
+		upstreams = onlySOCKS5(upstreams)
+	}
+
+	healthy := make([]*upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		if u.health.healthy.Load() {
+			healthy = append(healthy, u)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return upstreams
+	}
+	return healthy
+}
+
+func inGroup(upstreams []*upstream, group string) []*upstream {
+	members := make([]*upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		for _, g := range u.cfg.Groups {
+			if g == group {
+				members = append(members, u)
+				break
 			}
+		}
+	}
+	return members
+}
+
+func onlySOCKS5(upstreams []*upstream) []*upstream {
+	socks := make([]*upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		if u.cfg.Type == config.UpstreamTypeSOCKS5 {
+			socks = append(socks, u)
+		}
+	}
+	return socks
+}
+
+func UpstreamLabel(u config.UpstreamConfig) string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return upstreamAddress(u)
+}
 
-			if res.err != nil && firstSOCKS5Error == nil {
-				if socks5Err, ok := res.err.(*socks5.SOCKS5Error); ok {
-					firstSOCKS5Error = socks5Err
-				}
+func upstreamName(u config.UpstreamConfig) string {
+	if u.Name != "" {
+		return fmt.Sprintf("%s (%s)", u.Name, upstreamAddress(u))
+	}
+	return upstreamAddress(u)
+}
+
+func upstreamAddress(u config.UpstreamConfig) string {
+	if u.Type != config.UpstreamTypeDirect {
+		return u.Address
+	}
+	switch {
+	case u.Interface != "":
+		return "direct via " + u.Interface
+	case u.LocalAddress != "":
+		return "direct from " + u.LocalAddress
+	}
+	return "direct"
+}
+
+func credentials(u config.UpstreamConfig) *socks5.Credentials {
+	if u.Username == "" {
+		return nil
+	}
+	return &socks5.Credentials{
+		Username: u.Username,
+		Password: u.Password,
+	}
+}
+
+type result struct {
+	conn     net.Conn
+	bindAddr net.Addr
+	from     *upstream
+	dest     string
+	err      error
+	duration time.Duration
+}
+
+type Conn struct {
+	net.Conn
+	Upstream config.UpstreamConfig
+	BindAddr net.Addr
+	Duration time.Duration
+
+	from *upstream
+}
+
+type dialFunc func(ctx context.Context, u *upstream, target *socks5.TargetAddress) (net.Conn, net.Addr, error)
+
+func dialConnect(ctx context.Context, u *upstream, target *socks5.TargetAddress) (net.Conn, net.Addr, error) {
+	if n, ok := u.dialer.(negotiator); ok {
+		if conn := u.warm.take(); conn != nil {
+			bindAddr, err := n.connect(ctx, conn, target)
+			if err == nil {
+				return conn, bindAddr, nil
 			}
+			conn.Close()
 
-		case <-raceCtx.Done():
-			logger.Info("✗ %s race timeout after %dms", target, time.Since(raceStartTime).Milliseconds())
-			return nil, config.UpstreamConfig{}, fmt.Errorf("race timeout")
+			var socks5Err *socks5.SOCKS5Error
+			if errors.As(err, &socks5Err) || ctx.Err() != nil {
+				return nil, nil, err
+			}
+			logger.FromContext(ctx).Debug("warm connection to %s unusable, dialing a new one: %v", upstreamName(u.cfg), err)
 		}
 	}
 
-	logger.Info("✗ %s all upstreams failed", target)
-	if firstSOCKS5Error != nil {
-		return nil, config.UpstreamConfig{}, firstSOCKS5Error
+	return u.dialer.dial(ctx, target)
+}
+
+func dialBind(ctx context.Context, u *upstream, target *socks5.TargetAddress) (net.Conn, net.Addr, error) {
+	return socks5.DialBind(ctx, u.cfg.Address, credentials(u.cfg), target)
+}
+
+func dialNegotiated(ctx context.Context, u *upstream, target *socks5.TargetAddress) (net.Conn, net.Addr, error) {
+	n, ok := u.dialer.(negotiator)
+	if !ok {
+		return u.dialer.dial(ctx, target)
 	}
-	return nil, config.UpstreamConfig{}, fmt.Errorf("all upstreams failed")
+
+	if conn := u.warm.take(); conn != nil {
+		return conn, nil, nil
+	}
+
+	conn, err := n.negotiate(ctx)
+	return conn, nil, err
 }
 
-func (p *Pool) collectRaceStats(resultCh chan *result, remaining int) {
-	for i := 0; i < remaining; i++ {
-		res := <-resultCh
-		if res.conn != nil {
-			res.conn.Close()
+func (p *Pool) GetConn(ctx context.Context, target *socks5.TargetAddress, group string) (*Conn, error) {
+	candidates := p.candidates(group, false)
+
+	cache := p.affinityCache()
+	var key string
+	if cache != nil {
+		key = cache.key(target)
+		if u := cache.lookup(key, candidates); u != nil {
+			conn, err := p.dialSticky(ctx, target, u)
+			if err == nil {
+				cache.remember(key, u)
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			cache.forget(key, u)
+			logger.FromContext(ctx).With("upstream", UpstreamLabel(u.cfg)).
+				Info("sticky upstream %s for %s failed, racing: %v", upstreamName(u.cfg), key, err)
 		}
 	}
+
+	var conn *Conn
+	var err error
+	if p.raceConfig().Mode == config.RaceModeHandshake {
+		conn, err = p.raceHandshake(ctx, target, candidates)
+	} else {
+		conn, err = p.race(ctx, target, candidates, dialConnect)
+	}
+
+	if err == nil && cache != nil {
+		cache.remember(key, conn.from)
+	}
+	return conn, err
+}
+
+func (p *Pool) Bind(ctx context.Context, target *socks5.TargetAddress, group string) (*Conn, error) {
+	return p.race(ctx, target, p.candidates(group, true), dialBind)
 }
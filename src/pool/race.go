@@ -0,0 +1,293 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/metrics"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+type raceRun struct {
+	p          *Pool
+	ctx        context.Context
+	target     *socks5.TargetAddress
+	dial       dialFunc
+	candidates []*upstream
+	resultCh   chan *result
+	startTime  time.Time
+
+	staggered bool
+	delay     time.Duration
+	stagger   *time.Timer
+	staggerC  <-chan time.Time
+
+	started  int
+	received int
+
+	firstSOCKS5Error *socks5.SOCKS5Error
+	firstError       error
+}
+
+func (p *Pool) startRace(ctx context.Context, target *socks5.TargetAddress, candidates []*upstream, dial dialFunc) *raceRun {
+	raceCfg := p.raceConfig()
+	staggered := raceCfg.Strategy == config.RaceStrategyStaggered
+	switch raceCfg.Strategy {
+	case config.RaceStrategyStaggered:
+		candidates = byPerformance(candidates, target.Host)
+	case config.RaceStrategyTopK:
+		total := len(candidates)
+		candidates = topK(candidates, target.Host, raceCfg.TopK, raceCfg.Explore)
+		logger.FromContext(ctx).Debug("racing %d of %d upstreams for %s", len(candidates), total, target)
+	}
+
+	r := &raceRun{
+		p:          p,
+		ctx:        ctx,
+		target:     target,
+		dial:       dial,
+		candidates: candidates,
+		resultCh:   make(chan *result, len(candidates)),
+		startTime:  time.Now(),
+		staggered:  staggered,
+		delay:      time.Duration(raceCfg.StaggerDelay),
+	}
+
+	if len(candidates) == 0 {
+		r.firstError = fmt.Errorf("no eligible upstreams")
+	} else if staggered {
+		r.launch()
+		r.stagger = time.NewTimer(r.delay)
+		r.staggerC = r.stagger.C
+	} else {
+		for r.started < len(r.candidates) {
+			r.launch()
+		}
+	}
+
+	return r
+}
+
+func (r *raceRun) launch() {
+	u := r.candidates[r.started]
+	r.started++
+
+	go func() {
+		start := time.Now()
+		conn, bindAddr, err := r.dial(r.ctx, u, r.target)
+		duration := time.Since(start)
+
+		var authErr *socks5.AuthError
+		if errors.As(err, &authErr) {
+			logger.FromContext(r.ctx).With("upstream", UpstreamLabel(u.cfg)).Warn("✗ %s auth failed: %v", upstreamName(u.cfg), err)
+		}
+
+		r.resultCh <- &result{
+			conn:     conn,
+			bindAddr: bindAddr,
+			from:     u,
+			dest:     r.target.Host,
+			err:      err,
+			duration: duration,
+		}
+	}()
+}
+
+func (r *raceRun) launchNext() {
+	if r.started >= len(r.candidates) {
+		r.staggerC = nil
+		return
+	}
+	r.launch()
+	r.stagger.Reset(r.delay)
+}
+
+func (r *raceRun) recordFailure(res *result) {
+	r.p.recordResult(res, false)
+
+	if res.err != nil && r.firstError == nil {
+		r.firstError = res.err
+	}
+
+	if res.err != nil && r.firstSOCKS5Error == nil {
+		var socks5Err *socks5.SOCKS5Error
+		if errors.As(res.err, &socks5Err) {
+			r.firstSOCKS5Error = socks5Err
+		}
+	}
+
+	if r.staggered && r.received == r.started {
+		r.launchNext()
+	}
+}
+
+func (r *raceRun) next() (*result, error) {
+	for r.received < len(r.candidates) {
+		select {
+		case res := <-r.resultCh:
+			r.received++
+
+			if res.err == nil && res.conn != nil {
+				return res, nil
+			}
+			r.recordFailure(res)
+
+		case <-r.staggerC:
+			r.launchNext()
+
+		case <-r.ctx.Done():
+			metrics.RaceTimeouts.With(r.p.name).Inc()
+			elapsed := time.Since(r.startTime).Milliseconds()
+			logger.FromContext(r.ctx).With("race_ms", elapsed).Warn("✗ %s race timeout after %dms", r.target, elapsed)
+			return nil, fmt.Errorf("race timeout: %w", r.ctx.Err())
+		}
+	}
+
+	logger.FromContext(r.ctx).Warn("✗ %s all upstreams failed", r.target)
+	if r.firstSOCKS5Error != nil {
+		return nil, r.firstSOCKS5Error
+	}
+	if r.firstError != nil {
+		return nil, fmt.Errorf("all upstreams failed: %w", r.firstError)
+	}
+	return nil, fmt.Errorf("all upstreams failed")
+}
+
+func (r *raceRun) finish() {
+	if r.stagger != nil {
+		r.stagger.Stop()
+	}
+	go r.p.collectRaceStats(r.resultCh, r.started-r.received)
+}
+
+func (p *Pool) race(ctx context.Context, target *socks5.TargetAddress, candidates []*upstream, dial dialFunc) (*Conn, error) {
+	raceCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	r := p.startRace(raceCtx, target, candidates, dial)
+	defer r.finish()
+
+	res, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+
+	p.recordResult(res, true)
+	logger.FromContext(ctx).With("upstream", UpstreamLabel(res.from.cfg), "race_ms", res.duration.Milliseconds()).
+		Info("✓ %s -> %s (%dms)", target, upstreamName(res.from.cfg), res.duration.Milliseconds())
+
+	return &Conn{
+		Conn:     res.conn,
+		Upstream: res.from.cfg,
+		BindAddr: res.bindAddr,
+		Duration: res.duration,
+		from:     res.from,
+	}, nil
+}
+
+func (p *Pool) raceHandshake(ctx context.Context, target *socks5.TargetAddress, candidates []*upstream) (*Conn, error) {
+	raceCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	r := p.startRace(raceCtx, target, candidates, dialNegotiated)
+	defer r.finish()
+
+	for {
+		res, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if n, ok := res.from.dialer.(negotiator); ok {
+			bindAddr, err := n.connect(raceCtx, res.conn, target)
+			if err != nil {
+				logger.FromContext(ctx).Debug("connect via %s failed after handshake race: %v", upstreamName(res.from.cfg), err)
+				res.conn.Close()
+				res.conn = nil
+				res.err = err
+				r.recordFailure(res)
+				continue
+			}
+
+			res.bindAddr = bindAddr
+		}
+		p.recordResult(res, true)
+		elapsed := time.Since(r.startTime).Milliseconds()
+		logger.FromContext(ctx).With("upstream", UpstreamLabel(res.from.cfg), "race_ms", elapsed).
+			Info("✓ %s -> %s (%dms, handshake race)", target, upstreamName(res.from.cfg), elapsed)
+
+		return &Conn{
+			Conn:     res.conn,
+			Upstream: res.from.cfg,
+			BindAddr: res.bindAddr,
+			Duration: time.Since(r.startTime),
+			from:     res.from,
+		}, nil
+	}
+}
+
+func topK(upstreams []*upstream, dest string, k int, explore *int) []*upstream {
+	if k <= 0 || len(upstreams) <= k {
+		return upstreams
+	}
+
+	ranked := byPerformance(upstreams, dest)
+	selected := ranked[:k:k]
+
+	rest := ranked[k:]
+	n := 0
+	if explore != nil {
+		n = min(*explore, len(rest))
+	}
+	for _, i := range rand.Perm(len(rest))[:n] {
+		selected = append(selected, rest[i])
+	}
+	return selected
+}
+
+func byPerformance(upstreams []*upstream, dest string) []*upstream {
+	type scored struct {
+		u        *upstream
+		failures int
+		ewma     time.Duration
+	}
+
+	scoredUpstreams := make([]scored, len(upstreams))
+	for i, u := range upstreams {
+		failures, ewma := u.stats.score(dest)
+		scoredUpstreams[i] = scored{u: u, failures: failures, ewma: ewma}
+	}
+
+	sort.SliceStable(scoredUpstreams, func(i, j int) bool {
+		a, b := scoredUpstreams[i], scoredUpstreams[j]
+		if a.failures != b.failures {
+			return a.failures < b.failures
+		}
+		if (a.ewma == 0) != (b.ewma == 0) {
+			return a.ewma != 0
+		}
+		return a.ewma < b.ewma
+	})
+
+	ordered := make([]*upstream, len(upstreams))
+	for i, s := range scoredUpstreams {
+		ordered[i] = s.u
+	}
+	return ordered
+}
+
+func (p *Pool) collectRaceStats(resultCh chan *result, remaining int) {
+	for i := 0; i < remaining; i++ {
+		res := <-resultCh
+		p.recordResult(res, false)
+		if res.conn != nil {
+			res.conn.Close()
+		}
+	}
+}
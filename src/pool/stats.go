@@ -0,0 +1,264 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/metrics"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+const (
+	latencySampleSize  = 256
+	latencyEWMAAlpha   = 0.2
+	destLatencyEntries = 1024
+)
+
+const (
+	FailureTimeout = "timeout"
+	FailureRefused = "refused"
+	FailureDNS     = "dns"
+	FailureAuth    = "auth"
+	FailureReply   = "socks_reply"
+	FailureNetwork = "network"
+)
+
+type upstreamStats struct {
+	mu                  sync.Mutex
+	wins                uint64
+	losses              uint64
+	failures            map[string]uint64
+	consecutiveFailures int
+	ewma                time.Duration
+	samples             []time.Duration
+	next                int
+	dest                map[string]time.Duration
+}
+
+type UpstreamStats struct {
+	Name        string            `json:"name,omitempty"`
+	Address     string            `json:"address"`
+	Wins        uint64            `json:"wins"`
+	Losses      uint64            `json:"losses"`
+	Failures    map[string]uint64 `json:"failures"`
+	LatencyEWMA time.Duration     `json:"latency_ewma"`
+	LatencyP50  time.Duration     `json:"latency_p50"`
+	LatencyP90  time.Duration     `json:"latency_p90"`
+	LatencyP99  time.Duration     `json:"latency_p99"`
+}
+
+func classifyError(err error) string {
+	var authErr *socks5.AuthError
+	var socks5Err *socks5.SOCKS5Error
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &authErr):
+		return FailureAuth
+	case errors.As(err, &socks5Err):
+		return FailureReply
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return FailureTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	default:
+		return FailureNetwork
+	}
+}
+
+func (s *upstreamStats) recordLatency(d time.Duration) {
+	s.consecutiveFailures = 0
+
+	s.ewma = movingAverage(s.ewma, d)
+
+	if len(s.samples) < latencySampleSize {
+		s.samples = append(s.samples, d)
+		return
+	}
+	s.samples[s.next] = d
+	s.next = (s.next + 1) % latencySampleSize
+}
+
+func movingAverage(prev, d time.Duration) time.Duration {
+	if prev == 0 {
+		return d
+	}
+	return time.Duration(latencyEWMAAlpha*float64(d) + (1-latencyEWMAAlpha)*float64(prev))
+}
+
+func (s *upstreamStats) recordDestLatency(dest string, d time.Duration) {
+	if dest == "" || d <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dest == nil {
+		s.dest = make(map[string]time.Duration)
+	}
+	if _, ok := s.dest[dest]; !ok && len(s.dest) >= destLatencyEntries {
+		for evict := range s.dest {
+			delete(s.dest, evict)
+			break
+		}
+	}
+	s.dest[dest] = movingAverage(s.dest[dest], d)
+}
+
+func (s *upstreamStats) recordWin(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wins++
+	s.recordLatency(d)
+}
+
+func (s *upstreamStats) recordLoss(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.losses++
+	if d > 0 {
+		s.recordLatency(d)
+	}
+}
+
+func (s *upstreamStats) recordFailure(class string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures == nil {
+		s.failures = make(map[string]uint64)
+	}
+	s.failures[class]++
+	s.consecutiveFailures++
+}
+
+func (s *upstreamStats) score(dest string) (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if latency, ok := s.dest[dest]; ok {
+		return s.consecutiveFailures, latency
+	}
+	return s.consecutiveFailures, s.ewma
+}
+
+func (s *upstreamStats) snapshot() UpstreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := UpstreamStats{
+		Wins:        s.wins,
+		Losses:      s.losses,
+		Failures:    make(map[string]uint64, len(s.failures)),
+		LatencyEWMA: s.ewma,
+	}
+	for class, n := range s.failures {
+		snap.Failures[class] = n
+	}
+
+	if len(s.samples) > 0 {
+		sorted := slices.Clone(s.samples)
+		slices.Sort(sorted)
+		snap.LatencyP50 = percentile(sorted, 0.50)
+		snap.LatencyP90 = percentile(sorted, 0.90)
+		snap.LatencyP99 = percentile(sorted, 0.99)
+	}
+
+	return snap
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func (p *Pool) recordResult(res *result, won bool) {
+	stats := &res.from.stats
+	label := UpstreamLabel(res.from.cfg)
+
+	switch {
+	case won:
+		stats.recordWin(res.duration)
+		stats.recordDestLatency(res.dest, res.duration)
+		metrics.RaceOutcomes.With(p.name, label, "win", "").Inc()
+		metrics.RaceDuration.With(p.name, label).Observe(res.duration.Seconds())
+	case res.err == nil:
+		stats.recordLoss(res.duration)
+		stats.recordDestLatency(res.dest, res.duration)
+		metrics.RaceOutcomes.With(p.name, label, "loss", "").Inc()
+		metrics.RaceDuration.With(p.name, label).Observe(res.duration.Seconds())
+	case errors.Is(res.err, context.Canceled):
+		stats.recordLoss(0)
+		metrics.RaceOutcomes.With(p.name, label, "loss", "").Inc()
+	default:
+		class := classifyError(res.err)
+		stats.recordFailure(class)
+		metrics.RaceOutcomes.With(p.name, label, "failure", class).Inc()
+	}
+}
+
+func (p *Pool) Stats() []UpstreamStats {
+	upstreams := p.current()
+	stats := make([]UpstreamStats, len(upstreams))
+	for i, u := range upstreams {
+		stats[i] = u.statsSnapshot()
+	}
+	return stats
+}
+
+func (u *upstream) statsSnapshot() UpstreamStats {
+	snap := u.stats.snapshot()
+	snap.Name = u.cfg.Name
+	snap.Address = upstreamAddress(u.cfg)
+	return snap
+}
+
+func (p *Pool) RunStatsLogger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.logStats()
+		}
+	}
+}
+
+func (p *Pool) logStats() {
+	for _, u := range p.current() {
+		s := u.stats.snapshot()
+
+		var failures uint64
+		classes := make([]string, 0, len(s.Failures))
+		for class, n := range s.Failures {
+			failures += n
+			classes = append(classes, fmt.Sprintf("%s=%d", class, n))
+		}
+		sort.Strings(classes)
+
+		if s.Wins+s.Losses+failures == 0 {
+			continue
+		}
+
+		logger.With("upstream", UpstreamLabel(u.cfg)).Info("stats %s: wins=%d losses=%d failures=%d [%s] ewma=%dms p50=%dms p90=%dms p99=%dms",
+			upstreamName(u.cfg), s.Wins, s.Losses, failures, strings.Join(classes, " "),
+			s.LatencyEWMA.Milliseconds(), s.LatencyP50.Milliseconds(), s.LatencyP90.Milliseconds(), s.LatencyP99.Milliseconds())
+	}
+}
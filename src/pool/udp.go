@@ -0,0 +1,318 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+const udpFlowTimeout = 2 * time.Minute
+
+type UDPPacket struct {
+	Data     []byte
+	Source   *socks5.TargetAddress
+	Upstream config.UpstreamConfig
+}
+
+type udpUpstream struct {
+	upstream config.UpstreamConfig
+	assoc    *socks5.UDPAssociation
+}
+
+type udpFlow struct {
+	target    *socks5.TargetAddress
+	winner    *udpUpstream
+	pending   int
+	firstSent time.Time
+	lastSeen  time.Time
+}
+
+type UDPSession struct {
+	mu               sync.Mutex
+	upstreams        []*udpUpstream
+	flows            map[string]*udpFlow
+	perDatagramPorts map[uint16]bool
+	packets          chan UDPPacket
+	done             chan struct{}
+	cancel           context.CancelFunc
+	closeOnce        sync.Once
+	log              *logger.Logger
+}
+
+func (p *Pool) OpenUDP(ctx context.Context, perDatagramPorts []uint16) (*UDPSession, error) {
+	assocCtx, cancel := context.WithTimeout(ctx, p.timeout)
+
+	s := &UDPSession{
+		flows:            make(map[string]*udpFlow),
+		perDatagramPorts: make(map[uint16]bool),
+		packets:          make(chan UDPPacket, 64),
+		done:             make(chan struct{}),
+		cancel:           cancel,
+		log:              logger.FromContext(ctx),
+	}
+	for _, port := range perDatagramPorts {
+		s.perDatagramPorts[port] = true
+	}
+
+	candidates := p.candidates("", true)
+	readyCh := make(chan error, len(candidates))
+	raceStartTime := time.Now()
+
+	for _, upstream := range candidates {
+		go func(u config.UpstreamConfig) {
+			assoc, err := socks5.AssociateUDP(assocCtx, u.Address, credentials(u))
+			if err != nil {
+				s.log.Debug("udp associate via %s failed: %v", upstreamName(u), err)
+				readyCh <- err
+				return
+			}
+
+			us := &udpUpstream{upstream: u, assoc: assoc}
+			if !s.add(us) {
+				assoc.Close()
+				readyCh <- fmt.Errorf("udp session closed")
+				return
+			}
+
+			go s.readLoop(us)
+			readyCh <- nil
+		}(upstream.cfg)
+	}
+
+	var firstError error
+	for i := 0; i < len(candidates); i++ {
+		select {
+		case err := <-readyCh:
+			if err == nil {
+				s.log.Info("✓ udp associate ready (%dms)", time.Since(raceStartTime).Milliseconds())
+				go s.expireFlows()
+				return s, nil
+			}
+			if firstError == nil {
+				firstError = err
+			}
+
+		case <-assocCtx.Done():
+			s.Close()
+			s.log.Warn("✗ udp associate timeout after %dms", time.Since(raceStartTime).Milliseconds())
+			return nil, fmt.Errorf("udp associate timeout: %w", assocCtx.Err())
+		}
+	}
+
+	s.Close()
+	s.log.Warn("✗ udp associate failed on all upstreams")
+	if firstError != nil {
+		return nil, fmt.Errorf("all upstreams failed udp associate: %w", firstError)
+	}
+	return nil, fmt.Errorf("all upstreams failed udp associate")
+}
+
+func (s *UDPSession) add(us *udpUpstream) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	s.upstreams = append(s.upstreams, us)
+	return true
+}
+
+func (s *UDPSession) remove(us *udpUpstream) {
+	s.mu.Lock()
+	for i, u := range s.upstreams {
+		if u == us {
+			s.upstreams = append(s.upstreams[:i], s.upstreams[i+1:]...)
+			break
+		}
+	}
+	for _, flow := range s.flows {
+		if flow.winner == us {
+			flow.winner = nil
+		}
+	}
+	remaining := len(s.upstreams)
+	s.mu.Unlock()
+
+	us.assoc.Close()
+
+	if remaining == 0 {
+		s.log.Warn("✗ udp session lost all upstream associations")
+		s.Close()
+	}
+}
+
+func (s *UDPSession) WriteTo(data []byte, target *socks5.TargetAddress) error {
+	now := time.Now()
+	key := target.String()
+
+	s.mu.Lock()
+	flow, ok := s.flows[key]
+	if !ok {
+		flow = &udpFlow{target: target, firstSent: now}
+		s.flows[key] = flow
+	}
+	flow.lastSeen = now
+
+	perDatagram := s.perDatagramPorts[target.Port]
+	var dests []*udpUpstream
+	if flow.winner != nil && !perDatagram {
+		dests = []*udpUpstream{flow.winner}
+	} else {
+		dests = append(dests, s.upstreams...)
+	}
+	if perDatagram {
+		flow.pending++
+	}
+	s.mu.Unlock()
+
+	if len(dests) == 0 {
+		return fmt.Errorf("no udp upstreams available")
+	}
+
+	var lastErr error
+	sent := 0
+	for _, us := range dests {
+		if err := us.assoc.WriteTo(data, target); err != nil {
+			s.log.Debug("udp send to %s via %s failed: %v", target, upstreamName(us.upstream), err)
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+
+	if sent == 0 {
+		return fmt.Errorf("udp send to %s failed: %w", target, lastErr)
+	}
+	return nil
+}
+
+func (s *UDPSession) ReadFrom() (UDPPacket, error) {
+	select {
+	case pkt := <-s.packets:
+		return pkt, nil
+	case <-s.done:
+		return UDPPacket{}, fmt.Errorf("udp session closed")
+	}
+}
+
+func (s *UDPSession) readLoop(us *udpUpstream) {
+	buf := make([]byte, socks5.MaxUDPDatagramSize)
+	for {
+		data, source, err := us.assoc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				s.log.Debug("udp association via %s closed: %v", upstreamName(us.upstream), err)
+				s.remove(us)
+			}
+			return
+		}
+
+		if !s.accept(us, source) {
+			continue
+		}
+
+		pkt := UDPPacket{
+			Data:     append([]byte(nil), data...),
+			Source:   source,
+			Upstream: us.upstream,
+		}
+
+		select {
+		case s.packets <- pkt:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *UDPSession) accept(us *udpUpstream, source *socks5.TargetAddress) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := source.String()
+	flow, ok := s.flows[key]
+	if !ok {
+		flow = s.matchDomainFlow(source)
+		if flow == nil {
+			flow = &udpFlow{target: source, firstSent: time.Now()}
+		}
+		s.flows[key] = flow
+	}
+	flow.lastSeen = time.Now()
+
+	if s.perDatagramPorts[flow.target.Port] {
+		if flow.pending == 0 {
+			return false
+		}
+		flow.pending--
+		return true
+	}
+
+	if flow.winner == nil {
+		flow.winner = us
+		elapsed := time.Since(flow.firstSent).Milliseconds()
+		s.log.With("upstream", UpstreamLabel(us.upstream), "race_ms", elapsed).
+			Info("✓ udp %s -> %s (%dms)", flow.target, upstreamName(us.upstream), elapsed)
+	}
+
+	return flow.winner == us
+}
+
+func (s *UDPSession) matchDomainFlow(source *socks5.TargetAddress) *udpFlow {
+	for _, flow := range s.flows {
+		if flow.target.Type != socks5.AtypDomain || flow.target.Port != source.Port {
+			continue
+		}
+		if flow.winner == nil || flow.pending > 0 {
+			return flow
+		}
+	}
+	return nil
+}
+
+func (s *UDPSession) expireFlows() {
+	ticker := time.NewTicker(udpFlowTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			for key, flow := range s.flows {
+				if time.Since(flow.lastSeen) > udpFlowTimeout {
+					delete(s.flows, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *UDPSession) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		close(s.done)
+		upstreams := s.upstreams
+		s.upstreams = nil
+		s.mu.Unlock()
+
+		s.cancel()
+		for _, us := range upstreams {
+			us.assoc.Close()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,175 @@
+package pool
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/logger"
+)
+
+const warmRetryInterval = 5 * time.Second
+
+type warmPool struct {
+	mu     sync.Mutex
+	idle   []warmConn
+	refill chan struct{}
+}
+
+type warmConn struct {
+	conn    net.Conn
+	expires time.Time
+}
+
+func (w *warmPool) take() net.Conn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for len(w.idle) > 0 {
+		wc := w.idle[0]
+		w.idle = w.idle[1:]
+		if now.Before(wc.expires) {
+			select {
+			case w.refill <- struct{}{}:
+			default:
+			}
+			return wc.conn
+		}
+		wc.conn.Close()
+	}
+	return nil
+}
+
+func (w *warmPool) put(conn net.Conn, expires time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.idle = append(w.idle, warmConn{conn: conn, expires: expires})
+}
+
+func (w *warmPool) size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.idle)
+}
+
+func (w *warmPool) expire() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	kept := w.idle[:0]
+	for _, wc := range w.idle {
+		if now.Before(wc.expires) {
+			kept = append(kept, wc)
+			continue
+		}
+		wc.conn.Close()
+	}
+	w.idle = kept
+
+	if len(w.idle) == 0 {
+		return time.Time{}
+	}
+	return w.idle[0].expires
+}
+
+func (w *warmPool) closeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, wc := range w.idle {
+		wc.conn.Close()
+	}
+	w.idle = nil
+}
+
+func (p *Pool) RunWarmPool(ctx context.Context, cfg *config.WarmPoolConfig) {
+	running := make(map[*upstream]context.CancelFunc)
+	defer func() {
+		for _, cancel := range running {
+			cancel()
+		}
+	}()
+
+	for {
+		upstreams, changed := p.watch()
+		present := make(map[*upstream]bool)
+		for _, u := range upstreams {
+			present[u] = true
+			n, negotiates := u.dialer.(negotiator)
+			if _, ok := running[u]; ok || !negotiates {
+				continue
+			}
+			loopCtx, cancel := context.WithCancel(ctx)
+			running[u] = cancel
+			go p.warmLoop(loopCtx, u, n, cfg)
+		}
+
+		for u, cancel := range running {
+			if !present[u] {
+				cancel()
+				delete(running, u)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (p *Pool) warmLoop(ctx context.Context, u *upstream, n negotiator, cfg *config.WarmPoolConfig) {
+	defer u.warm.closeAll()
+
+	maxIdle := time.Duration(cfg.MaxIdle)
+
+	for {
+		next := u.warm.expire()
+
+		var dialErr error
+		for u.warm.size() < cfg.Size {
+			dialCtx, cancel := context.WithTimeout(ctx, p.timeout)
+			conn, err := n.negotiate(dialCtx)
+			cancel()
+
+			if ctx.Err() != nil {
+				if conn != nil {
+					conn.Close()
+				}
+				return
+			}
+			if err != nil {
+				dialErr = err
+				break
+			}
+
+			expires := time.Now().Add(maxIdle)
+			u.warm.put(conn, expires)
+			if next.IsZero() {
+				next = expires
+			}
+		}
+
+		wait := warmRetryInterval
+		if dialErr != nil {
+			logger.Debug("warm pool for %s: %v", upstreamName(u.cfg), dialErr)
+		} else if !next.IsZero() {
+			wait = time.Until(next)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-u.warm.refill:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package route
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+type Decision struct {
+	Rule   int
+	Action string
+	Group  string
+}
+
+var defaultDecision = Decision{Rule: -1, Action: config.RuleActionRace}
+
+type Router struct {
+	rules []*rule
+}
+
+type rule struct {
+	domains  map[string]bool
+	suffixes []string
+	regexes  []*regexp.Regexp
+	nets     []*net.IPNet
+	ports    []portRange
+	decision Decision
+}
+
+type portRange struct {
+	first uint16
+	last  uint16
+}
+
+func New(cfgs []config.RuleConfig) (*Router, error) {
+	r := &Router{rules: make([]*rule, 0, len(cfgs))}
+
+	for i, cfg := range cfgs {
+		ru := &rule{
+			domains:  make(map[string]bool),
+			decision: Decision{Rule: i, Action: cfg.Action, Group: cfg.Group},
+		}
+		if ru.decision.Action == "" {
+			ru.decision.Action = config.RuleActionRace
+		}
+
+		for _, domain := range cfg.Domains {
+			ru.domains[normalizeHost(domain)] = true
+		}
+		for _, suffix := range cfg.DomainSuffixes {
+			ru.suffixes = append(ru.suffixes, strings.TrimPrefix(normalizeHost(suffix), "."))
+		}
+		for _, expr := range cfg.DomainRegexes {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid domain regex %s: %w", i, expr, err)
+			}
+			ru.regexes = append(ru.regexes, re)
+		}
+		for _, cidr := range cfg.CIDRs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid cidr %s: %w", i, cidr, err)
+			}
+			ru.nets = append(ru.nets, ipNet)
+		}
+		for _, ports := range cfg.Ports {
+			first, last, err := config.ParsePortRange(ports)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			ru.ports = append(ru.ports, portRange{first: first, last: last})
+		}
+
+		r.rules = append(r.rules, ru)
+	}
+
+	return r, nil
+}
+
+func (r *Router) Match(target *socks5.TargetAddress) Decision {
+	if r == nil {
+		return defaultDecision
+	}
+
+	host := normalizeHost(target.Host)
+	ip := config.ParseHostIP(host)
+
+	for _, ru := range r.rules {
+		if ru.matchHost(host, ip) && ru.matchPort(target.Port) {
+			return ru.decision
+		}
+	}
+	return defaultDecision
+}
+
+func (ru *rule) matchHost(host string, ip net.IP) bool {
+	if len(ru.domains) == 0 && len(ru.suffixes) == 0 && len(ru.regexes) == 0 && len(ru.nets) == 0 {
+		return true
+	}
+
+	if ip != nil {
+		for _, ipNet := range ru.nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ru.domains[host] {
+		return true
+	}
+	for _, suffix := range ru.suffixes {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	for _, re := range ru.regexes {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ru *rule) matchPort(port uint16) bool {
+	if len(ru.ports) == 0 {
+		return true
+	}
+	for _, pr := range ru.ports {
+		if port >= pr.first && port <= pr.last {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
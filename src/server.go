@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bdim404/parallel-socks/src/admin"
+	"github.com/bdim404/parallel-socks/src/config"
+	"github.com/bdim404/parallel-socks/src/listener"
+	"github.com/bdim404/parallel-socks/src/logger"
+	"github.com/bdim404/parallel-socks/src/metrics"
+)
+
+type server struct {
+	ctx       context.Context
+	wg        sync.WaitGroup
+	logLevel  string
+	logFormat string
+	cfg       *config.Config
+
+	mu        sync.Mutex
+	listeners map[string]*runningListener
+
+	metricsCancel context.CancelFunc
+
+	admin       *admin.Server
+	adminListen string
+	adminCancel context.CancelFunc
+	adminDone   chan struct{}
+}
+
+type runningListener struct {
+	l   *listener.Listener
+	cfg config.ListenerConfig
+}
+
+func newServer(ctx context.Context, logLevel, logFormat string) *server {
+	return &server{
+		ctx:       ctx,
+		logLevel:  logLevel,
+		logFormat: logFormat,
+		listeners: make(map[string]*runningListener),
+	}
+}
+
+func (s *server) apply(cfg *config.Config) error {
+	if s.logLevel != "" {
+		cfg.LogLevel = s.logLevel
+	}
+	if s.logFormat != "" {
+		cfg.LogFormat = s.logFormat
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("validate config: %w", err)
+	}
+
+	logger.SetLevel(cfg.LogLevel)
+	logger.SetFormat(cfg.LogFormat)
+
+	if cfg.AccessLog != nil {
+		if err := logger.SetAccessLog(true, cfg.AccessLog.Path, cfg.AccessLog.Format); err != nil {
+			return fmt.Errorf("configure access log: %w", err)
+		}
+	} else {
+		logger.SetAccessLog(false, "", "")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	seen := make(map[string]bool)
+	for _, listenerCfg := range cfg.Listeners {
+		seen[listenerCfg.Listen] = true
+	}
+
+	for addr, running := range s.listeners {
+		if !seen[addr] {
+			logger.Info("stopping listener %s", addr)
+			running.l.Close()
+			delete(s.listeners, addr)
+		}
+	}
+
+	for _, listenerCfg := range cfg.Listeners {
+		if running, ok := s.listeners[listenerCfg.Listen]; ok {
+			if reflect.DeepEqual(running.cfg, listenerCfg) {
+				continue
+			}
+			if err := running.l.Reload(&listenerCfg); err != nil {
+				errs = append(errs, fmt.Errorf("reload listener %s: %w", listenerCfg.Listen, err))
+				continue
+			}
+			running.cfg = listenerCfg
+			continue
+		}
+
+		l, err := listener.New(&listenerCfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("create listener for %s: %w", listenerCfg.Listen, err))
+			continue
+		}
+
+		s.listeners[listenerCfg.Listen] = &runningListener{l: l, cfg: listenerCfg}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := l.Serve(s.ctx); err != nil {
+				logger.Error("listener error: %v", err)
+			}
+		}()
+	}
+
+	if s.cfg == nil || !reflect.DeepEqual(s.cfg.Metrics, cfg.Metrics) {
+		s.startMetrics(cfg.Metrics)
+	}
+
+	if s.cfg == nil || !reflect.DeepEqual(s.cfg.Admin, cfg.Admin) {
+		s.startAdmin(cfg.Admin)
+	}
+
+	s.cfg = cfg
+	return errors.Join(errs...)
+}
+
+func (s *server) startMetrics(cfg *config.MetricsConfig) {
+	if s.metricsCancel != nil {
+		s.metricsCancel()
+		s.metricsCancel = nil
+	}
+
+	if cfg == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.metricsCancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := metrics.Serve(ctx, cfg.Listen); err != nil {
+			logger.Error("metrics server error: %v", err)
+		}
+	}()
+}
+
+func (s *server) startAdmin(cfg *config.AdminConfig) {
+	if cfg != nil && s.adminCancel != nil && s.adminListen == cfg.Listen {
+		s.admin.SetToken(cfg.Token)
+		return
+	}
+
+	if s.adminCancel != nil {
+		s.adminCancel()
+		<-s.adminDone
+		s.adminCancel = nil
+		s.admin = nil
+	}
+
+	if cfg == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	done := make(chan struct{})
+	s.admin = admin.New(cfg.Token, s)
+	s.adminListen = cfg.Listen
+	s.adminCancel = cancel
+	s.adminDone = done
+
+	srv := s.admin
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+		if err := srv.Serve(ctx, cfg.Listen); err != nil {
+			logger.Error("admin server error: %v", err)
+		}
+	}()
+}
+
+func (s *server) Listeners() []*listener.Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listeners := make([]*listener.Listener, 0, len(s.listeners))
+	for _, running := range s.listeners {
+		listeners = append(listeners, running.l)
+	}
+	sort.Slice(listeners, func(i, j int) bool { return listeners[i].Addr() < listeners[j].Addr() })
+	return listeners
+}
+
+func (s *server) reload(path string) {
+	if path == "" {
+		logger.Warn("reload requested but no config file is in use")
+		return
+	}
+
+	logger.Info("reloading config from %s", path)
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		logger.Error("reload failed, keeping current config: %v", err)
+		return
+	}
+
+	if err := s.apply(cfg); err != nil {
+		logger.Warn("reload applied with errors: %v", err)
+		return
+	}
+
+	logger.Info("reload complete")
+}
+
+func watchConfig(ctx context.Context, path string, interval time.Duration, reloadCh chan<- struct{}) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+
+			select {
+			case reloadCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
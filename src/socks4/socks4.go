@@ -0,0 +1,100 @@
+package socks4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/bdim404/parallel-socks/src/socks5"
+)
+
+const (
+	Version4 = 0x04
+
+	CmdConnect = 0x01
+	CmdBind    = 0x02
+
+	RepGranted          = 0x5A
+	RepRejected         = 0x5B
+	RepIdentUnreachable = 0x5C
+	RepUserIDMismatch   = 0x5D
+
+	maxFieldLength = 255
+)
+
+func ParseRequest(conn net.Conn) (byte, *socks5.TargetAddress, string, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, "", fmt.Errorf("read request: %w", err)
+	}
+
+	if header[0] != Version4 {
+		return 0, nil, "", fmt.Errorf("unsupported version: %d", header[0])
+	}
+
+	cmd := header[1]
+	port := binary.BigEndian.Uint16(header[2:4])
+	ip := net.IP(header[4:8])
+
+	userID, err := readString(conn)
+	if err != nil {
+		return 0, nil, "", fmt.Errorf("read user id: %w", err)
+	}
+
+	target := &socks5.TargetAddress{
+		Type: socks5.AtypIPv4,
+		Host: ip.String(),
+		Port: port,
+	}
+
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := readString(conn)
+		if err != nil {
+			return 0, nil, "", fmt.Errorf("read domain: %w", err)
+		}
+		if domain == "" {
+			return 0, nil, "", fmt.Errorf("empty domain in socks4a request")
+		}
+		target.Type = socks5.AtypDomain
+		target.Host = domain
+	}
+
+	if cmd != CmdConnect && cmd != CmdBind {
+		return cmd, target, userID, fmt.Errorf("unsupported command: %d", cmd)
+	}
+
+	return cmd, target, userID, nil
+}
+
+func SendReply(conn net.Conn, code byte, bindAddr net.Addr) error {
+	reply := make([]byte, 8)
+	reply[1] = code
+
+	if addr, ok := bindAddr.(*net.TCPAddr); ok {
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			binary.BigEndian.PutUint16(reply[2:4], uint16(addr.Port))
+			copy(reply[4:8], ip4)
+		}
+	}
+
+	_, err := conn.Write(reply)
+	return err
+}
+
+func readString(conn net.Conn) (string, error) {
+	buf := make([]byte, 0, 32)
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(buf), nil
+		}
+		if len(buf) >= maxFieldLength {
+			return "", fmt.Errorf("field longer than %d bytes", maxFieldLength)
+		}
+		buf = append(buf, b[0])
+	}
+}
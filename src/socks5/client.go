@@ -11,36 +11,116 @@ import (
 	"github.com/bdim404/parallel-socks/src/logger"
 )
 
-func DialSOCKS5(ctx context.Context, proxyAddr string, target *TargetAddress) (net.Conn, error) {
-	dialer := &net.Dialer{
-		Timeout: 2 * time.Second,
+func DialSOCKS5(ctx context.Context, proxyAddr string, creds *Credentials, target *TargetAddress) (net.Conn, net.Addr, error) {
+	conn, err := dialProxy(ctx, proxyAddr, creds)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	bindAddr, err := clientConnect(conn, target)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, bindAddr, nil
+}
+
+func DialBind(ctx context.Context, proxyAddr string, creds *Credentials, target *TargetAddress) (net.Conn, net.Addr, error) {
+	conn, err := dialProxy(ctx, proxyAddr, creds)
 	if err != nil {
-		return nil, fmt.Errorf("dial proxy: %w", err)
+		return nil, nil, err
+	}
+
+	bindAddr, err := clientCommand(conn, CmdBind, target)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, bindAddr, nil
+}
+
+func DialNegotiated(ctx context.Context, proxyAddr string, creds *Credentials) (net.Conn, error) {
+	conn, err := dialProxy(ctx, proxyAddr, creds)
+	if err != nil {
+		return nil, err
 	}
 
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func Connect(ctx context.Context, conn net.Conn, target *TargetAddress) (net.Addr, error) {
 	if deadline, ok := ctx.Deadline(); ok {
 		conn.SetDeadline(deadline)
 	}
 
-	if err := clientNegotiate(conn); err != nil {
-		conn.Close()
+	bindAddr, err := clientConnect(conn, target)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return bindAddr, nil
+}
+
+func ReadBindReply(conn net.Conn) (net.Addr, error) {
+	peerAddr, err := readReply(conn)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := clientConnect(conn, target); err != nil {
+	logger.Trace("socks5: bind accepted peer %v", peerAddr)
+	return peerAddr, nil
+}
+
+func Probe(ctx context.Context, proxyAddr string, creds *Credentials, target *TargetAddress) error {
+	conn, err := dialProxy(ctx, proxyAddr, creds)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if target == nil {
+		return nil
+	}
+
+	_, err = clientConnect(conn, target)
+	return err
+}
+
+func dialProxy(ctx context.Context, proxyAddr string, creds *Credentials) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: 2 * time.Second,
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, &ProxyDialError{Err: err}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := clientNegotiate(conn, creds); err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	conn.SetDeadline(time.Time{})
 	return conn, nil
 }
 
-func clientNegotiate(conn net.Conn) error {
-	_, err := conn.Write([]byte{Version5, 1, MethodNoAuth})
+func clientNegotiate(conn net.Conn, creds *Credentials) error {
+	greeting := []byte{Version5, 1, MethodNoAuth}
+	if creds != nil {
+		greeting = []byte{Version5, 2, MethodNoAuth, MethodUserPass}
+	}
+
+	_, err := conn.Write(greeting)
 	if err != nil {
 		return fmt.Errorf("write negotiation: %w", err)
 	}
@@ -54,107 +134,136 @@ func clientNegotiate(conn net.Conn) error {
 		return fmt.Errorf("unsupported version: %d", buf[0])
 	}
 
-	if buf[1] != MethodNoAuth {
-		return fmt.Errorf("unsupported auth method: %d", buf[1])
+	switch {
+	case buf[1] == MethodNoAuth:
+		return nil
+	case buf[1] == MethodUserPass && creds != nil:
+		return clientUserPass(conn, creds)
+	case buf[1] == MethodNoAcceptable && creds == nil:
+		return &AuthError{Message: "proxy requires authentication but no credentials are configured"}
+	case buf[1] == MethodNoAcceptable:
+		return &AuthError{Message: "proxy rejected all offered auth methods"}
+	}
+
+	return fmt.Errorf("unsupported auth method: %d", buf[1])
+}
+
+func clientUserPass(conn net.Conn, creds *Credentials) error {
+	if len(creds.Username) > 255 || len(creds.Password) > 255 {
+		return &AuthError{Message: "username or password longer than 255 bytes"}
+	}
+
+	req := make([]byte, 0, 3+len(creds.Username)+len(creds.Password))
+	req = append(req, UserPassVersion, byte(len(creds.Username)))
+	req = append(req, creds.Username...)
+	req = append(req, byte(len(creds.Password)))
+	req = append(req, creds.Password...)
+
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("write auth request: %w", err)
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return fmt.Errorf("read auth response: %w", err)
 	}
 
+	if buf[0] != UserPassVersion {
+		return fmt.Errorf("unsupported auth version: %d", buf[0])
+	}
+
+	if buf[1] != UserPassSuccess {
+		return &AuthError{Message: fmt.Sprintf("authentication failed for user %q: status %d", creds.Username, buf[1])}
+	}
+
+	logger.Debug("socks5: authenticated to proxy as %s", creds.Username)
 	return nil
 }
 
-func clientConnect(conn net.Conn, target *TargetAddress) error {
+func clientConnect(conn net.Conn, target *TargetAddress) (net.Addr, error) {
+	return clientCommand(conn, CmdConnect, target)
+}
+
+func clientCommand(conn net.Conn, cmd byte, target *TargetAddress) (net.Addr, error) {
 	req := make([]byte, 0, 262)
-	req = append(req, Version5, CmdConnect, 0x00)
+	req = append(req, Version5, cmd, 0x00)
 
 	if len(target.RawRequest) > 0 {
 		req = append(req, target.RawRequest...)
 	} else {
-		switch target.Type {
-		case AtypIPv4:
-			req = append(req, AtypIPv4)
-			ip := net.ParseIP(target.Host)
-			if ip == nil {
-				return fmt.Errorf("invalid IPv4 address: %s", target.Host)
-			}
-			req = append(req, ip.To4()...)
-
-		case AtypIPv6:
-			req = append(req, AtypIPv6)
-			ip := net.ParseIP(target.Host)
-			if ip == nil {
-				return fmt.Errorf("invalid IPv6 address: %s", target.Host)
-			}
-			req = append(req, ip.To16()...)
-
-		case AtypDomain:
-			req = append(req, AtypDomain)
-			if len(target.Host) > 255 {
-				return fmt.Errorf("domain name too long: %d", len(target.Host))
-			}
-			req = append(req, byte(len(target.Host)))
-			req = append(req, []byte(target.Host)...)
-
-		default:
-			return fmt.Errorf("unsupported address type: %d", target.Type)
+		var err error
+		req, err = appendAddress(req, target)
+		if err != nil {
+			return nil, err
 		}
-
-		portBuf := make([]byte, 2)
-		binary.BigEndian.PutUint16(portBuf, target.Port)
-		req = append(req, portBuf...)
 	}
 
-	logger.Debug("socks5: sending connect request (%d bytes) to %s:%d", len(req), target.Host, target.Port)
+	logger.Trace("socks5: sending %s request (%d bytes) to %s:%d", commandName(cmd), len(req), target.Host, target.Port)
 	if _, err := conn.Write(req); err != nil {
-		return fmt.Errorf("write connect request: %w", err)
+		return nil, fmt.Errorf("write %s request: %w", commandName(cmd), err)
+	}
+
+	bindAddr, err := readReply(conn)
+	if err != nil {
+		return nil, err
 	}
 
+	logger.Trace("socks5: %s handshake completed successfully", commandName(cmd))
+	return bindAddr, nil
+}
+
+func readReply(conn net.Conn) (net.Addr, error) {
 	reply := make([]byte, 4)
 	if _, err := io.ReadFull(conn, reply); err != nil {
-		return fmt.Errorf("read reply header: %w", err)
+		return nil, fmt.Errorf("read reply header: %w", err)
 	}
 
-	logger.Debug("socks5: received reply: ver=%d, rep=%d, rsv=%d, atyp=%d", reply[0], reply[1], reply[2], reply[3])
+	logger.Trace("socks5: received reply: ver=%d, rep=%d, rsv=%d, atyp=%d", reply[0], reply[1], reply[2], reply[3])
 
 	if reply[0] != Version5 {
-		return fmt.Errorf("unsupported version: %d", reply[0])
+		return nil, fmt.Errorf("unsupported version: %d", reply[0])
 	}
 
 	if reply[1] != RepSuccess {
-		return &SOCKS5Error{
+		return nil, &SOCKS5Error{
 			ReplyCode: reply[1],
 			Message:   fmt.Sprintf("connection failed: reply code %d", reply[1]),
 		}
 	}
 
 	atyp := reply[3]
+	var bindAddr net.Addr
 	switch atyp {
 	case AtypIPv4:
-		discard := make([]byte, 6)
-		n, err := io.ReadFull(conn, discard)
+		addr := make([]byte, 6)
+		n, err := io.ReadFull(conn, addr)
 		if err != nil {
-			logger.Debug("socks5: error - failed to read IPv4 bind addr (read %d/6 bytes): %v", n, err)
-			return fmt.Errorf("read bind addr: %w", err)
+			logger.Trace("socks5: error - failed to read IPv4 bind addr (read %d/6 bytes): %v", n, err)
+			return nil, fmt.Errorf("read bind addr: %w", err)
 		}
-		logger.Debug("socks5: read bind addr: %d.%d.%d.%d:%d", discard[0], discard[1], discard[2], discard[3], uint16(discard[4])<<8|uint16(discard[5]))
+		bindAddr = &net.TCPAddr{IP: net.IP(addr[:4]), Port: int(binary.BigEndian.Uint16(addr[4:]))}
+		logger.Trace("socks5: read bind addr: %s", bindAddr)
 	case AtypIPv6:
-		discard := make([]byte, 18)
-		n, err := io.ReadFull(conn, discard)
+		addr := make([]byte, 18)
+		n, err := io.ReadFull(conn, addr)
 		if err != nil {
-			logger.Debug("socks5: error - failed to read IPv6 bind addr (read %d/18 bytes): %v", n, err)
-			return fmt.Errorf("read bind addr: %w", err)
+			logger.Trace("socks5: error - failed to read IPv6 bind addr (read %d/18 bytes): %v", n, err)
+			return nil, fmt.Errorf("read bind addr: %w", err)
 		}
+		bindAddr = &net.TCPAddr{IP: net.IP(addr[:16]), Port: int(binary.BigEndian.Uint16(addr[16:]))}
+		logger.Trace("socks5: read bind addr: %s", bindAddr)
 	case AtypDomain:
 		lenBuf := make([]byte, 1)
 		if _, err := io.ReadFull(conn, lenBuf); err != nil {
-			return fmt.Errorf("read bind domain length: %w", err)
+			return nil, fmt.Errorf("read bind domain length: %w", err)
 		}
 		discard := make([]byte, int(lenBuf[0])+2)
 		n, err := io.ReadFull(conn, discard)
 		if err != nil {
-			logger.Debug("socks5: error - failed to read domain bind addr (read %d/%d bytes): %v", n, len(discard), err)
-			return fmt.Errorf("read bind addr: %w", err)
+			logger.Trace("socks5: error - failed to read domain bind addr (read %d/%d bytes): %v", n, len(discard), err)
+			return nil, fmt.Errorf("read bind addr: %w", err)
 		}
 	}
 
-	logger.Debug("socks5: connect handshake completed successfully")
-	return nil
+	return bindAddr, nil
 }
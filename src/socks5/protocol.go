@@ -1,30 +1,43 @@
 package socks5
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"net"
+	"os"
 	"strconv"
+	"syscall"
 )
 
 const (
 	Version5 = 0x05
 
-	MethodNoAuth = 0x00
+	MethodNoAuth       = 0x00
+	MethodUserPass     = 0x02
 	MethodNoAcceptable = 0xFF
 
-	CmdConnect = 0x01
+	UserPassVersion = 0x01
+	UserPassSuccess = 0x00
+	UserPassFailure = 0x01
 
-	AtypIPv4 = 0x01
+	CmdConnect      = 0x01
+	CmdBind         = 0x02
+	CmdUDPAssociate = 0x03
+
+	AtypIPv4   = 0x01
 	AtypDomain = 0x03
-	AtypIPv6 = 0x04
-
-	RepSuccess = 0x00
-	RepGeneralFailure = 0x01
-	RepConnectionNotAllowed = 0x02
-	RepNetworkUnreachable = 0x03
-	RepHostUnreachable = 0x04
-	RepConnectionRefused = 0x05
-	RepTTLExpired = 0x06
-	RepCommandNotSupported = 0x07
+	AtypIPv6   = 0x04
+
+	RepSuccess                 = 0x00
+	RepGeneralFailure          = 0x01
+	RepConnectionNotAllowed    = 0x02
+	RepNetworkUnreachable      = 0x03
+	RepHostUnreachable         = 0x04
+	RepConnectionRefused       = 0x05
+	RepTTLExpired              = 0x06
+	RepCommandNotSupported     = 0x07
 	RepAddressTypeNotSupported = 0x08
 )
 
@@ -37,6 +50,66 @@ func (e *SOCKS5Error) Error() string {
 	return e.Message
 }
 
+type ProxyDialError struct {
+	Err error
+}
+
+func (e *ProxyDialError) Error() string {
+	return "dial proxy: " + e.Err.Error()
+}
+
+func (e *ProxyDialError) Unwrap() error {
+	return e.Err
+}
+
+func ReplyCodeForError(err error) byte {
+	var socks5Err *SOCKS5Error
+	if errors.As(err, &socks5Err) {
+		return socks5Err.ReplyCode
+	}
+
+	var proxyErr *ProxyDialError
+	if errors.As(err, &proxyErr) {
+		return RepGeneralFailure
+	}
+
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return RepTTLExpired
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return RepConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return RepNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return RepHostUnreachable
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return RepTTLExpired
+	}
+
+	return RepGeneralFailure
+}
+
+type AuthError struct {
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+type Credentials struct {
+	Username string
+	Password string
+}
+
+type Authenticator interface {
+	Authenticate(username, password string) bool
+}
+
 type TargetAddress struct {
 	Type       byte
 	Host       string
@@ -51,3 +124,145 @@ func (t *TargetAddress) String() string {
 func (t *TargetAddress) Network() string {
 	return "tcp"
 }
+
+func commandName(cmd byte) string {
+	switch cmd {
+	case CmdConnect:
+		return "connect"
+	case CmdBind:
+		return "bind"
+	case CmdUDPAssociate:
+		return "udp associate"
+	default:
+		return fmt.Sprintf("command %d", cmd)
+	}
+}
+
+func appendAddress(b []byte, target *TargetAddress) ([]byte, error) {
+	switch target.Type {
+	case AtypIPv4:
+		b = append(b, AtypIPv4)
+		ip := net.ParseIP(target.Host)
+		if ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 address: %s", target.Host)
+		}
+		b = append(b, ip.To4()...)
+
+	case AtypIPv6:
+		b = append(b, AtypIPv6)
+		ip := net.ParseIP(target.Host)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv6 address: %s", target.Host)
+		}
+		b = append(b, ip.To16()...)
+
+	case AtypDomain:
+		b = append(b, AtypDomain)
+		if len(target.Host) > 255 {
+			return nil, fmt.Errorf("domain name too long: %d", len(target.Host))
+		}
+		b = append(b, byte(len(target.Host)))
+		b = append(b, []byte(target.Host)...)
+
+	default:
+		return nil, fmt.Errorf("unsupported address type: %d", target.Type)
+	}
+
+	return binary.BigEndian.AppendUint16(b, target.Port), nil
+}
+
+func parseAddress(b []byte) (*TargetAddress, int, error) {
+	if len(b) < 1 {
+		return nil, 0, fmt.Errorf("missing address type")
+	}
+
+	atyp := b[0]
+	var host string
+	n := 1
+	switch atyp {
+	case AtypIPv4:
+		if len(b) < n+4+2 {
+			return nil, 0, fmt.Errorf("short IPv4 address")
+		}
+		host = net.IP(b[n : n+4]).String()
+		n += 4
+
+	case AtypIPv6:
+		if len(b) < n+16+2 {
+			return nil, 0, fmt.Errorf("short IPv6 address")
+		}
+		host = net.IP(b[n : n+16]).String()
+		n += 16
+
+	case AtypDomain:
+		if len(b) < n+1 {
+			return nil, 0, fmt.Errorf("missing domain length")
+		}
+		domainLen := int(b[n])
+		n++
+		if len(b) < n+domainLen+2 {
+			return nil, 0, fmt.Errorf("short domain address")
+		}
+		host = string(b[n : n+domainLen])
+		n += domainLen
+
+	default:
+		return nil, 0, fmt.Errorf("unsupported address type: %d", atyp)
+	}
+
+	port := binary.BigEndian.Uint16(b[n : n+2])
+	n += 2
+
+	return &TargetAddress{
+		Type: atyp,
+		Host: host,
+		Port: port,
+	}, n, nil
+}
+
+func ParseTargetAddress(addr string) (*TargetAddress, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+
+	target := &TargetAddress{
+		Type: AtypDomain,
+		Host: host,
+		Port: uint16(port),
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			target.Type = AtypIPv4
+			target.Host = ip4.String()
+		} else {
+			target.Type = AtypIPv6
+		}
+	}
+
+	return target, nil
+}
+
+func TargetFromAddr(addr net.Addr) *TargetAddress {
+	var ip net.IP
+	var port int
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	default:
+		return &TargetAddress{Type: AtypIPv4, Host: "0.0.0.0"}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &TargetAddress{Type: AtypIPv4, Host: ip4.String(), Port: uint16(port)}
+	}
+	return &TargetAddress{Type: AtypIPv6, Host: ip.String(), Port: uint16(port)}
+}
@@ -8,50 +8,100 @@ import (
 	"net"
 )
 
-func HandleNegotiation(conn net.Conn) error {
+func HandleNegotiation(conn net.Conn, auth Authenticator) (string, error) {
 	buf := make([]byte, 257)
 	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
-		return fmt.Errorf("read version and nmethods: %w", err)
+		return "", fmt.Errorf("read version and nmethods: %w", err)
 	}
 
 	version := buf[0]
 	nmethods := buf[1]
 
 	if version != Version5 {
-		return fmt.Errorf("unsupported version: %d", version)
+		return "", fmt.Errorf("unsupported version: %d", version)
 	}
 
 	if nmethods == 0 {
-		return fmt.Errorf("no methods provided")
+		return "", fmt.Errorf("no methods provided")
 	}
 
 	if _, err := io.ReadFull(conn, buf[:nmethods]); err != nil {
-		return fmt.Errorf("read methods: %w", err)
+		return "", fmt.Errorf("read methods: %w", err)
 	}
 
-	hasNoAuth := false
+	wanted := byte(MethodNoAuth)
+	if auth != nil {
+		wanted = MethodUserPass
+	}
+
+	offered := false
 	for i := byte(0); i < nmethods; i++ {
-		if buf[i] == MethodNoAuth {
-			hasNoAuth = true
+		if buf[i] == wanted {
+			offered = true
 			break
 		}
 	}
 
-	if !hasNoAuth {
+	if !offered {
 		conn.Write([]byte{Version5, MethodNoAcceptable})
-		return fmt.Errorf("no acceptable methods")
+		return "", fmt.Errorf("no acceptable methods")
 	}
 
-	_, err := conn.Write([]byte{Version5, MethodNoAuth})
-	return err
+	if _, err := conn.Write([]byte{Version5, wanted}); err != nil {
+		return "", err
+	}
+
+	if auth == nil {
+		return "", nil
+	}
+
+	return handleUserPass(conn, auth)
 }
 
-func ParseRequest(conn net.Conn) (*TargetAddress, error) {
+func handleUserPass(conn net.Conn, auth Authenticator) (string, error) {
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", fmt.Errorf("read auth version and ulen: %w", err)
+	}
+
+	if buf[0] != UserPassVersion {
+		return "", fmt.Errorf("unsupported auth version: %d", buf[0])
+	}
+
+	ulen := buf[1]
+	if _, err := io.ReadFull(conn, buf[:ulen]); err != nil {
+		return "", fmt.Errorf("read username: %w", err)
+	}
+	username := string(buf[:ulen])
+
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return "", fmt.Errorf("read plen: %w", err)
+	}
+
+	plen := buf[0]
+	if _, err := io.ReadFull(conn, buf[:plen]); err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	password := string(buf[:plen])
+
+	if !auth.Authenticate(username, password) {
+		conn.Write([]byte{UserPassVersion, UserPassFailure})
+		return "", fmt.Errorf("authentication failed for user %q", username)
+	}
+
+	if _, err := conn.Write([]byte{UserPassVersion, UserPassSuccess}); err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
+
+func ParseRequest(conn net.Conn) (byte, *TargetAddress, error) {
 	var rawBuffer bytes.Buffer
 
 	buf := make([]byte, 4)
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return nil, fmt.Errorf("read request header: %w", err)
+		return 0, nil, fmt.Errorf("read request header: %w", err)
 	}
 
 	version := buf[0]
@@ -61,11 +111,14 @@ func ParseRequest(conn net.Conn) (*TargetAddress, error) {
 	rawBuffer.WriteByte(atyp)
 
 	if version != Version5 {
-		return nil, fmt.Errorf("unsupported version: %d", version)
+		return 0, nil, fmt.Errorf("unsupported version: %d", version)
 	}
 
-	if cmd != CmdConnect {
-		return nil, fmt.Errorf("unsupported command: %d", cmd)
+	if cmd != CmdConnect && cmd != CmdBind && cmd != CmdUDPAssociate {
+		return 0, nil, &SOCKS5Error{
+			ReplyCode: RepCommandNotSupported,
+			Message:   fmt.Sprintf("unsupported command: %d", cmd),
+		}
 	}
 
 	var host string
@@ -73,7 +126,7 @@ func ParseRequest(conn net.Conn) (*TargetAddress, error) {
 	case AtypIPv4:
 		addr := make([]byte, 4)
 		if _, err := io.ReadFull(conn, addr); err != nil {
-			return nil, fmt.Errorf("read IPv4 address: %w", err)
+			return 0, nil, fmt.Errorf("read IPv4 address: %w", err)
 		}
 		rawBuffer.Write(addr)
 		host = net.IP(addr).String()
@@ -81,7 +134,7 @@ func ParseRequest(conn net.Conn) (*TargetAddress, error) {
 	case AtypIPv6:
 		addr := make([]byte, 16)
 		if _, err := io.ReadFull(conn, addr); err != nil {
-			return nil, fmt.Errorf("read IPv6 address: %w", err)
+			return 0, nil, fmt.Errorf("read IPv6 address: %w", err)
 		}
 		rawBuffer.Write(addr)
 		host = net.IP(addr).String()
@@ -89,29 +142,32 @@ func ParseRequest(conn net.Conn) (*TargetAddress, error) {
 	case AtypDomain:
 		lenBuf := make([]byte, 1)
 		if _, err := io.ReadFull(conn, lenBuf); err != nil {
-			return nil, fmt.Errorf("read domain length: %w", err)
+			return 0, nil, fmt.Errorf("read domain length: %w", err)
 		}
 		rawBuffer.Write(lenBuf)
 		domainLen := lenBuf[0]
 		domain := make([]byte, domainLen)
 		if _, err := io.ReadFull(conn, domain); err != nil {
-			return nil, fmt.Errorf("read domain: %w", err)
+			return 0, nil, fmt.Errorf("read domain: %w", err)
 		}
 		rawBuffer.Write(domain)
 		host = string(domain)
 
 	default:
-		return nil, fmt.Errorf("unsupported address type: %d", atyp)
+		return 0, nil, &SOCKS5Error{
+			ReplyCode: RepAddressTypeNotSupported,
+			Message:   fmt.Sprintf("unsupported address type: %d", atyp),
+		}
 	}
 
 	portBuf := make([]byte, 2)
 	if _, err := io.ReadFull(conn, portBuf); err != nil {
-		return nil, fmt.Errorf("read port: %w", err)
+		return 0, nil, fmt.Errorf("read port: %w", err)
 	}
 	rawBuffer.Write(portBuf)
 	port := binary.BigEndian.Uint16(portBuf)
 
-	return &TargetAddress{
+	return cmd, &TargetAddress{
 		Type:       atyp,
 		Host:       host,
 		Port:       port,
@@ -123,31 +179,11 @@ func SendReply(conn net.Conn, status byte, bindAddr net.Addr) error {
 	reply := make([]byte, 0, 22)
 	reply = append(reply, Version5, status, 0x00)
 
-	if bindAddr == nil {
-		reply = append(reply, AtypIPv4)
-		reply = append(reply, 0, 0, 0, 0)
-		reply = append(reply, 0, 0)
-	} else {
-		tcpAddr, ok := bindAddr.(*net.TCPAddr)
-		if !ok {
-			reply = append(reply, AtypIPv4)
-			reply = append(reply, 0, 0, 0, 0)
-			reply = append(reply, 0, 0)
-		} else {
-			ip4 := tcpAddr.IP.To4()
-			if ip4 != nil {
-				reply = append(reply, AtypIPv4)
-				reply = append(reply, ip4...)
-			} else {
-				reply = append(reply, AtypIPv6)
-				reply = append(reply, tcpAddr.IP.To16()...)
-			}
-			portBuf := make([]byte, 2)
-			binary.BigEndian.PutUint16(portBuf, uint16(tcpAddr.Port))
-			reply = append(reply, portBuf...)
-		}
+	reply, err := appendAddress(reply, TargetFromAddr(bindAddr))
+	if err != nil {
+		return err
 	}
 
-	_, err := conn.Write(reply)
+	_, err = conn.Write(reply)
 	return err
 }
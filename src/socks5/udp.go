@@ -0,0 +1,124 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const MaxUDPDatagramSize = 65535
+
+type UDPAssociation struct {
+	control   net.Conn
+	relay     *net.UDPConn
+	closeOnce sync.Once
+}
+
+func ParseUDPDatagram(b []byte) (*TargetAddress, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, fmt.Errorf("short udp datagram: %d bytes", len(b))
+	}
+
+	if b[0] != 0 || b[1] != 0 {
+		return nil, nil, fmt.Errorf("invalid udp datagram reserved bytes")
+	}
+
+	if b[2] != 0 {
+		return nil, nil, fmt.Errorf("fragmented udp datagrams are not supported (frag=%d)", b[2])
+	}
+
+	target, n, err := parseAddress(b[3:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse udp datagram address: %w", err)
+	}
+
+	return target, b[3+n:], nil
+}
+
+func AppendUDPDatagram(b []byte, target *TargetAddress, data []byte) ([]byte, error) {
+	b = append(b, 0, 0, 0)
+	b, err := appendAddress(b, target)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, data...), nil
+}
+
+func AssociateUDP(ctx context.Context, proxyAddr string, creds *Credentials) (*UDPAssociation, error) {
+	conn, err := dialProxy(ctx, proxyAddr, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	bindAddr, err := clientCommand(conn, CmdUDPAssociate, &TargetAddress{Type: AtypIPv4, Host: "0.0.0.0"})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	relayAddr := &net.UDPAddr{}
+	if tcpAddr, ok := bindAddr.(*net.TCPAddr); ok {
+		relayAddr.IP = tcpAddr.IP
+		relayAddr.Port = tcpAddr.Port
+	}
+	if relayAddr.IP == nil || relayAddr.IP.IsUnspecified() {
+		relayAddr.IP = conn.RemoteAddr().(*net.TCPAddr).IP
+	}
+
+	relay, err := net.DialUDP("udp", nil, relayAddr)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dial udp relay %s: %w", relayAddr, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	a := &UDPAssociation{
+		control: conn,
+		relay:   relay,
+	}
+
+	go func() {
+		io.Copy(io.Discard, conn)
+		a.Close()
+	}()
+
+	return a, nil
+}
+
+func (a *UDPAssociation) WriteTo(data []byte, target *TargetAddress) error {
+	datagram, err := AppendUDPDatagram(make([]byte, 0, len(data)+262), target, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.relay.Write(datagram)
+	return err
+}
+
+func (a *UDPAssociation) ReadFrom(buf []byte) ([]byte, *TargetAddress, error) {
+	for {
+		n, err := a.relay.Read(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		source, data, err := ParseUDPDatagram(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		return data, source, nil
+	}
+}
+
+func (a *UDPAssociation) Close() error {
+	a.closeOnce.Do(func() {
+		a.relay.Close()
+		a.control.Close()
+	})
+	return nil
+}